@@ -0,0 +1,78 @@
+package action
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// ErrUnsupported is returned when the action is not supported by the platform or filesystem.
+var ErrUnsupported = errors.New("operation is not supported")
+
+// Action replaces duplicate file dst with the content of the canonical file src.
+type Action func(src, dst string) error
+
+// Reflink replaces dst with a copy-on-write clone of src. Both files share data blocks
+// but keep independent metadata. It returns ErrUnsupported when the filesystem cannot
+// clone files.
+func Reflink(src, dst string) error {
+	return replace(src, dst, clone, true)
+}
+
+// Hardlink replaces dst with a hard link to src.
+func Hardlink(src, dst string) error {
+	return replace(src, dst, os.Link, false)
+}
+
+// Remove deletes dst, leaving src the only copy.
+func Remove(_, dst string) error {
+	return os.Remove(dst)
+}
+
+// WithFallback builds an Action that tries primary first and resorts to fallback
+// if primary is not supported.
+func WithFallback(primary, fallback Action) Action {
+	return func(src, dst string) error {
+		err := primary(src, dst)
+		if err == ErrUnsupported {
+			return fallback(src, dst)
+		}
+		return err
+	}
+}
+
+// Counter for unique temporary names
+var tmpCounter uint64
+
+// replace safely substitutes dst: new file is created next to dst under a temporary name
+// using create function and then atomically renamed over dst. On failure dst is untouched.
+// If keepMode is set, the new file gets permissions of the replaced one.
+func replace(src, dst string, create func(src, tmp string) error, keepMode bool) error {
+	// Keep permissions of the replaced file
+	fi, err := os.Stat(dst)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(filepath.Dir(dst),
+		fmt.Sprintf(".%s.dups-%d-%d", filepath.Base(dst), os.Getpid(), atomic.AddUint64(&tmpCounter, 1)))
+
+	if err = create(src, tmp); err != nil {
+		return err
+	}
+
+	if keepMode {
+		if err = os.Chmod(tmp, fi.Mode().Perm()); err != nil {
+			_ = os.Remove(tmp)
+			return err
+		}
+	}
+
+	if err = os.Rename(tmp, dst); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
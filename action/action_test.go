@@ -0,0 +1,75 @@
+package action
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Check that the directory holds only the named files, e.g. no temporary leftovers
+func checkDir(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(names) {
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Name())
+		}
+		t.Errorf("directory has %q, want %q", got, names)
+	}
+}
+
+func TestReflink(t *testing.T) {
+	paths := writeFiles(t, "data", "data")
+	if err := os.Chmod(paths[1], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Reflink(paths[0], paths[1])
+	if errors.Is(err, ErrUnsupported) {
+		checkContent(t, paths[1], "data")
+		checkDir(t, filepath.Dir(paths[0]), "a", "b")
+		t.Skip("filesystem can't clone files")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContent(t, paths[1], "data")
+	if fi, err := os.Stat(paths[1]); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("clone lost mode of the replaced file: %v", fi.Mode())
+	}
+	checkDir(t, filepath.Dir(paths[0]), "a", "b")
+}
+
+func TestWithFallback(t *testing.T) {
+	unsupported := func(string, string) error { return ErrUnsupported }
+	failing := func(string, string) error { return errors.New("failed") }
+
+	paths := writeFiles(t, "data", "data")
+	if err := WithFallback(unsupported, Hardlink)(paths[0], paths[1]); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := os.Stat(paths[0])
+	b, _ := os.Stat(paths[1])
+	if !os.SameFile(a, b) {
+		t.Error("fallback not used")
+	}
+
+	// Real errors are not hidden by the fallback
+	if err := WithFallback(failing, Hardlink)(paths[0], paths[1]); err == nil || err.Error() != "failed" {
+		t.Errorf("got %v, want primary error", err)
+	}
+}
+
+func TestHardlinkMissing(t *testing.T) {
+	paths := writeFiles(t, "data")
+	dir := filepath.Dir(paths[0])
+	if err := Hardlink(paths[0], filepath.Join(dir, "missing")); err == nil {
+		t.Error("no error for missing target")
+	}
+	checkDir(t, dir, "a")
+}
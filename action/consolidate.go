@@ -0,0 +1,145 @@
+package action
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/caelifer/dups/node"
+)
+
+// Consolidation describes how a duplicate group is collapsed onto a single device:
+// the canonical file on the target device is kept (or copied there first), other
+// copies on that device are replaced with it and copies on other devices are removed.
+type Consolidation struct {
+	Canonical string   // File on the target device to keep
+	Source    string   // File to copy canonical from if there is no copy on the target device yet
+	Link      []string // Copies on the target device to replace with canonical
+	Remove    []string // Copies on other devices to remove
+	Size      int64    // File size
+}
+
+// NewConsolidation builds a Consolidation of the group of identical files of the given
+// size onto the device of the directory dir. Paths must be sorted in order of preference.
+func NewConsolidation(paths []string, dir string, size int64) (Consolidation, error) {
+	c := Consolidation{Size: size}
+
+	target, err := DeviceOf(dir)
+	if err != nil {
+		return c, err
+	}
+
+	// Split copies by device
+	for _, p := range paths {
+		dev, err := DeviceOf(p)
+		if err != nil {
+			return c, err
+		}
+		switch {
+		case dev != target:
+			c.Remove = append(c.Remove, p)
+		case c.Canonical == "":
+			c.Canonical = p
+		default:
+			c.Link = append(c.Link, p)
+		}
+	}
+
+	// No copy on the target device yet, create one
+	if c.Canonical == "" {
+		c.Source = paths[0]
+		c.Canonical = filepath.Join(dir, filepath.Base(paths[0]))
+	}
+	return c, nil
+}
+
+// Execute performs consolidation using link to replace copies on the target device.
+// Copies on other devices are only removed once the canonical file is in place. Every
+// copy is compared with the canonical file byte for byte right before it's replaced or
+// removed, and skipped if it differs.
+func (c Consolidation) Execute(link Action) []error {
+	var errs []error
+
+	if c.Source != "" {
+		if err := copyFile(c.Source, c.Canonical); err != nil {
+			// Never remove anything without the canonical copy
+			return []error{err}
+		}
+		if err := checkSame(c.Source, c.Canonical); err != nil {
+			_ = os.Remove(c.Canonical)
+			return []error{err}
+		}
+	}
+
+	for _, p := range c.Link {
+		err := checkSame(c.Canonical, p)
+		if err == nil {
+			err = link(c.Canonical, p)
+		}
+		if err != nil {
+			errs = append(errs, &os.PathError{Op: "link", Path: p, Err: err})
+		}
+	}
+
+	for _, p := range c.Remove {
+		err := checkSame(c.Canonical, p)
+		if err == nil {
+			err = os.Remove(p)
+		}
+		if err != nil {
+			errs = append(errs, &os.PathError{Op: "remove", Path: p, Err: err})
+		}
+	}
+	return errs
+}
+
+// Verify re-hashes the canonical file, see Plan.Verify.
+func (c Consolidation) Verify(hash string) error {
+	return verifyHash(c.Canonical, c.Size, hash)
+}
+
+// Make sure the copy has the content of the canonical file
+func checkSame(canonical, dup string) error {
+	same, err := node.SameContent(canonical, dup)
+	if err != nil {
+		return err
+	}
+	if !same {
+		return fmt.Errorf("content differs from %s", canonical)
+	}
+	return nil
+}
+
+// Copy src to new file dst, refusing to overwrite existing files.
+func copyFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(dst)
+		}
+	}()
+
+	n, err := io.Copy(out, in)
+	if err == nil && n != fi.Size() {
+		err = fmt.Errorf("%s: partial copy", dst)
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package action
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Create files with the given content under a new temporary directory, returning their paths
+func writeFiles(t *testing.T, contents ...string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	var paths []string
+	for i, content := range contents {
+		path := filepath.Join(dir, string(rune('a'+i)))
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// Check that path has the content
+func checkContent(t *testing.T, path, want string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Errorf("%s has %q, want %q", path, data, want)
+	}
+}
+
+func TestConsolidationSkipsChangedCopies(t *testing.T) {
+	paths := writeFiles(t, "same", "same", "diff", "same", "diff")
+	c := Consolidation{Canonical: paths[0], Link: paths[1:3], Remove: paths[3:], Size: 4}
+
+	errs := c.Execute(Hardlink)
+	if len(errs) != 2 {
+		t.Fatalf("got errors %v, want 2", errs)
+	}
+
+	// Identical copies replaced and removed
+	a, _ := os.Stat(paths[0])
+	b, _ := os.Stat(paths[1])
+	if !os.SameFile(a, b) {
+		t.Errorf("%s not linked", paths[1])
+	}
+	if _, err := os.Stat(paths[3]); !os.IsNotExist(err) {
+		t.Errorf("%s not removed", paths[3])
+	}
+
+	// Different ones left alone
+	checkContent(t, paths[2], "diff")
+	checkContent(t, paths[4], "diff")
+}
+
+func TestConsolidationCopiesCanonical(t *testing.T) {
+	paths := writeFiles(t, "data", "data")
+	canonical := filepath.Join(t.TempDir(), "copy")
+	c := Consolidation{Source: paths[0], Canonical: canonical, Remove: paths, Size: 4}
+
+	if errs := c.Execute(Hardlink); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	checkContent(t, canonical, "data")
+	for _, p := range paths {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s not removed", p)
+		}
+	}
+}
+
+func TestConsolidationVerify(t *testing.T) {
+	paths := writeFiles(t, "data")
+	c := Consolidation{Canonical: paths[0], Size: 4}
+
+	if err := c.Verify("bogus"); err == nil {
+		t.Error("no error for wrong hash")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package action
+
+// DeviceOf is not supported on this platform.
+func DeviceOf(path string) (uint64, error) {
+	return 0, ErrUnsupported
+}
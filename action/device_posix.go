@@ -0,0 +1,22 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package action
+
+import (
+	"os"
+	"syscall"
+)
+
+// DeviceOf returns ID of the device the file resides on.
+func DeviceOf(path string) (uint64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, ErrUnsupported
+	}
+	return uint64(st.Dev), nil
+}
@@ -0,0 +1,41 @@
+package action
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeviceOf(t *testing.T) {
+	paths := writeFiles(t, "a", "b")
+	dev, err := DeviceOf(paths[0])
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip("device IDs not supported")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other, err := DeviceOf(paths[1]); err != nil || other != dev {
+		t.Errorf("sibling file on device %d (%v), want %d", other, err, dev)
+	}
+	if _, err := DeviceOf(filepath.Join(filepath.Dir(paths[0]), "missing")); err == nil {
+		t.Error("no error for missing file")
+	}
+}
+
+func TestFreeSpace(t *testing.T) {
+	paths := writeFiles(t, "a")
+	free, err := FreeSpace(paths[0])
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip("free space not supported")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free == 0 {
+		t.Error("no free space reported for a writable filesystem")
+	}
+	if _, err := FreeSpace(filepath.Join(filepath.Dir(paths[0]), "missing")); err == nil {
+		t.Error("no error for missing file")
+	}
+}
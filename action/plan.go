@@ -0,0 +1,113 @@
+package action
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/caelifer/dups/node"
+)
+
+// Plan describes how a single duplicate group is deduplicated: every target is
+// replaced with the content of the canonical file.
+type Plan struct {
+	Canonical string   // File to keep
+	Targets   []string // Duplicates to replace
+	Linked    []string // Duplicates already sharing storage with canonical
+	Size      int64    // File size
+	Reclaim   int64    // Projected number of reclaimed bytes
+}
+
+// NewPlan builds a Plan for the group of identical files of the given size. Targets
+// already sharing storage with the canonical file (hard links) are dropped, and
+// hard links to each other are only accounted once in the projected reclaim.
+func NewPlan(canonical string, others []string, size int64) Plan {
+	p := Plan{Canonical: canonical, Size: size}
+
+	// Already seen inodes
+	seen := make([]os.FileInfo, 0, len(others)+1)
+	if fi, err := os.Stat(canonical); err == nil {
+		seen = append(seen, fi)
+	}
+
+	for _, path := range others {
+		fi, err := os.Stat(path)
+		if err != nil {
+			// Will fail in Execute too, keep it to report the error
+			p.Targets = append(p.Targets, path)
+			continue
+		}
+
+		// Nothing to do if linked to canonical already
+		if len(seen) > 0 && os.SameFile(seen[0], fi) {
+			p.Linked = append(p.Linked, path)
+			continue
+		}
+
+		p.Targets = append(p.Targets, path)
+		if !sameAsAny(fi, seen) {
+			p.Reclaim += size
+			seen = append(seen, fi)
+		}
+	}
+	return p
+}
+
+// Execute applies act to all targets. Each target is compared with the canonical file
+// byte for byte right before, and skipped if it differs. It returns the number of actually
+// reclaimed bytes and the list of errors for targets that were skipped.
+func (p Plan) Execute(act Action) (int64, []error) {
+	var (
+		reclaimed int64
+		errs      []error
+		seen      []os.FileInfo // Replaced inodes
+	)
+
+	for _, path := range p.Targets {
+		fi, err := os.Stat(path)
+		if err == nil {
+			err = checkSame(p.Canonical, path)
+		}
+		if err == nil {
+			err = act(p.Canonical, path)
+		}
+		if err != nil {
+			errs = append(errs, &os.PathError{Op: "dedup", Path: path, Err: err})
+			continue
+		}
+
+		// Space is only freed once per inode
+		if !sameAsAny(fi, seen) {
+			reclaimed += p.Size
+			seen = append(seen, fi)
+		}
+	}
+	return reclaimed, errs
+}
+
+// Helper to check if fi refers to any of the files in the list
+func sameAsAny(fi os.FileInfo, list []os.FileInfo) bool {
+	for _, x := range list {
+		if os.SameFile(fi, x) {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify re-hashes the canonical file and checks it still matches hash computed before
+// the action. Mismatch indicates that the canonical file got corrupted.
+func (p Plan) Verify(hash string) error {
+	return verifyHash(p.Canonical, p.Size, hash)
+}
+
+// Check the file of the given size still has the hash
+func verifyHash(path string, size int64, hash string) error {
+	n := &node.Node{Path: path, Size: size}
+	if err := n.CalculateHash(); err != nil {
+		return err
+	}
+	if n.Hash != hash {
+		return fmt.Errorf("%s: content changed after action, hash %s, expected %s", path, n.Hash, hash)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package action
+
+import (
+	"os"
+	"testing"
+
+	"github.com/caelifer/dups/node"
+)
+
+func TestPlanSkipsDifferentTargets(t *testing.T) {
+	paths := writeFiles(t, "same", "same", "diff")
+	p := NewPlan(paths[0], paths[1:], 4)
+
+	n, errs := p.Execute(Remove)
+	if len(errs) != 1 || n != 4 {
+		t.Fatalf("reclaimed %d bytes with errors %v, want 4 and 1 error", n, errs)
+	}
+	if _, err := os.Stat(paths[1]); !os.IsNotExist(err) {
+		t.Errorf("%s not removed", paths[1])
+	}
+	checkContent(t, paths[2], "diff")
+}
+
+func TestPlanVerify(t *testing.T) {
+	paths := writeFiles(t, "same", "same")
+	n := &node.Node{Path: paths[0], Size: 4}
+	if err := n.CalculateHash(); err != nil {
+		t.Fatal(err)
+	}
+	p := NewPlan(paths[0], paths[1:], 4)
+
+	if _, errs := p.Execute(Hardlink); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if err := p.Verify(n.Hash); err != nil {
+		t.Errorf("intact canonical file: %v", err)
+	}
+
+	// Damaged canonical file is detected
+	if err := os.WriteFile(paths[0], []byte("oops"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Verify(n.Hash); err == nil {
+		t.Error("changed canonical file not detected")
+	}
+	if err := os.Remove(paths[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Verify(n.Hash); err == nil {
+		t.Error("missing canonical file not detected")
+	}
+}
@@ -0,0 +1,30 @@
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package action
+
+// #include <stdlib.h>
+// #include <sys/clonefile.h>
+import "C"
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// clone creates dst as a copy-on-write clone of src using clonefile(2).
+func clone(src, dst string) error {
+	csrc := C.CString(src)
+	defer C.free(unsafe.Pointer(csrc))
+	cdst := C.CString(dst)
+	defer C.free(unsafe.Pointer(cdst))
+
+	if rc, err := C.clonefile(csrc, cdst, 0); rc != 0 {
+		switch err {
+		case syscall.ENOTSUP, syscall.EXDEV:
+			return ErrUnsupported
+		}
+		return err
+	}
+	return nil
+}
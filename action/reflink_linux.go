@@ -0,0 +1,36 @@
+package action
+
+import (
+	"os"
+	"syscall"
+)
+
+// FICLONE ioctl request code, _IOW(0x94, 9, int)
+const ficlone = 0x40049409
+
+// clone creates dst as a copy-on-write clone of src using FICLONE ioctl.
+func clone(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, out.Fd(), ficlone, in.Fd())
+	err = out.Close()
+
+	if errno != 0 {
+		_ = os.Remove(dst)
+		switch errno {
+		case syscall.EOPNOTSUPP, syscall.ENOTTY, syscall.EXDEV, syscall.EINVAL:
+			return ErrUnsupported
+		}
+		return errno
+	}
+	return err
+}
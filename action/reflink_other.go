@@ -0,0 +1,9 @@
+//go:build (!linux && !darwin) || (darwin && !cgo)
+// +build !linux,!darwin darwin,!cgo
+
+package action
+
+// clone is not available on this platform.
+func clone(src, dst string) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,54 @@
+package action
+
+import (
+	"fmt"
+	"os"
+)
+
+// State is the projected state of a file after deduplication.
+type State string
+
+const (
+	Keep State = "keep" // File stays as is and serves as canonical copy
+	Link State = "link" // File is replaced with a link to canonical copy
+	Skip State = "skip" // File stays as is because it can't or needn't be replaced
+)
+
+// Outcome describes what deduplication does to a single file.
+type Outcome struct {
+	Path      string
+	State     State
+	Canonical string // Canonical file for Link and Skip states
+	Reason    string // Why file is skipped
+}
+
+func (o Outcome) String() string {
+	switch o.State {
+	case Keep:
+		return fmt.Sprintf("%s:%q", o.State, o.Path)
+	case Link:
+		return fmt.Sprintf("%s:%q:%q", o.State, o.Path, o.Canonical)
+	default:
+		return fmt.Sprintf("%s:%q:%q:%s", o.State, o.Path, o.Canonical, o.Reason)
+	}
+}
+
+// Simulate projects the state of all group members after the plan is executed,
+// without touching the filesystem beyond stat calls.
+func (p Plan) Simulate() []Outcome {
+	res := make([]Outcome, 0, len(p.Targets)+len(p.Linked)+1)
+	res = append(res, Outcome{Path: p.Canonical, State: Keep})
+
+	for _, path := range p.Linked {
+		res = append(res, Outcome{Path: path, State: Skip, Canonical: p.Canonical, Reason: "already linked"})
+	}
+
+	for _, path := range p.Targets {
+		if _, err := os.Stat(path); err != nil {
+			res = append(res, Outcome{Path: path, State: Skip, Canonical: p.Canonical, Reason: err.Error()})
+			continue
+		}
+		res = append(res, Outcome{Path: path, State: Link, Canonical: p.Canonical})
+	}
+	return res
+}
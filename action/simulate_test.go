@@ -0,0 +1,59 @@
+package action
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSimulate(t *testing.T) {
+	paths := writeFiles(t, "data", "data")
+	dir := filepath.Dir(paths[0])
+	linked := filepath.Join(dir, "linked")
+	if err := os.Link(paths[0], linked); err != nil {
+		t.Skip("hard links not supported:", err)
+	}
+	missing := filepath.Join(dir, "missing")
+
+	p := NewPlan(paths[0], []string{linked, paths[1], missing}, 4)
+	got := p.Simulate()
+
+	want := []Outcome{
+		{Path: paths[0], State: Keep},
+		{Path: linked, State: Skip, Canonical: paths[0], Reason: "already linked"},
+		{Path: paths[1], State: Link, Canonical: paths[0]},
+		{Path: missing, State: Skip, Canonical: paths[0]},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if want[i].Reason == "" && got[i].State == Skip {
+			want[i].Reason = got[i].Reason // Error text is system specific
+		}
+		if got[i] != want[i] {
+			t.Errorf("outcome %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+	if got[3].Reason == "" {
+		t.Error("no reason to skip missing file")
+	}
+	checkDir(t, dir, "a", "b", "linked")
+}
+
+func TestOutcomeString(t *testing.T) {
+	tests := []struct {
+		o    Outcome
+		want string
+	}{
+		{Outcome{Path: "a b", State: Keep}, `keep:"a b"`},
+		{Outcome{Path: "b", State: Link, Canonical: "a"}, `link:"b":"a"`},
+		{Outcome{Path: "c", State: Skip, Canonical: "a", Reason: "already linked"}, `skip:"c":"a":already linked`},
+	}
+
+	for _, tt := range tests {
+		if got := tt.o.String(); got != tt.want {
+			t.Errorf("got %s, want %s", got, tt.want)
+		}
+	}
+}
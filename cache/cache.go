@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Cache maps files identified by path, size and modification time to their content
+// hash, so that unchanged files need not be hashed again by the next run. It is safe
+// for concurrent use.
+type Cache struct {
+	mu      sync.Mutex
+	algo    string
+	entries map[string]entry
+}
+
+// Cached hash of a single file
+type entry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"` // Unix nanoseconds
+	Hash    string `json:"hash"`
+}
+
+// On-disk form of the cache
+type file struct {
+	Algorithm string           `json:"algorithm"`
+	Files     map[string]entry `json:"files"`
+}
+
+// New creates an empty Cache of hashes calculated with the named algorithm.
+func New(algo string) *Cache {
+	return &Cache{algo: algo, entries: make(map[string]entry)}
+}
+
+// Load reads the cache previously written by Save. Hashes calculated with another
+// algorithm than algo are discarded.
+func Load(r io.Reader, algo string) (*Cache, error) {
+	var f file
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, err
+	}
+
+	c := New(algo)
+	if f.Algorithm == algo && f.Files != nil {
+		c.entries = f.Files
+	}
+	return c, nil
+}
+
+// Lookup returns the cached hash of the file, unless its size or modification time
+// changed since the hash was stored.
+func (c *Cache) Lookup(path string, size int64, mtime time.Time) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[path]
+	if !ok || e.Size != size || e.ModTime != mtime.UnixNano() {
+		return "", false
+	}
+	return e.Hash, true
+}
+
+// Store puts the hash of the file to the cache, replacing the stale one.
+func (c *Cache) Store(path string, size int64, mtime time.Time, hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[path] = entry{Size: size, ModTime: mtime.UnixNano(), Hash: hash}
+}
+
+// Len returns the number of cached hashes.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Save writes the cache to w.
+func (c *Cache) Save(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return json.NewEncoder(w).Encode(file{Algorithm: c.algo, Files: c.entries})
+}
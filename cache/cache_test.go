@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLookup(t *testing.T) {
+	mtime := time.Date(2020, 4, 17, 1, 3, 7, 123456789, time.UTC)
+	c := New("sha1")
+	c.Store("/a", 10, mtime, "hash-a")
+
+	tests := []struct {
+		name  string
+		path  string
+		size  int64
+		mtime time.Time
+		want  string
+		ok    bool
+	}{
+		{"unchanged", "/a", 10, mtime, "hash-a", true},
+		{"other zone", "/a", 10, mtime.In(time.FixedZone("x", 3600)), "hash-a", true},
+		{"size changed", "/a", 11, mtime, "", false},
+		{"mtime changed", "/a", 10, mtime.Add(time.Nanosecond), "", false},
+		{"unknown", "/b", 10, mtime, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := c.Lookup(tt.path, tt.size, tt.mtime)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: got %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	// Rehashed file replaces the stale entry
+	later := mtime.Add(time.Second)
+	c.Store("/a", 11, later, "hash-a2")
+	if got, ok := c.Lookup("/a", 11, later); !ok || got != "hash-a2" {
+		t.Errorf("got %q, %v after update", got, ok)
+	}
+	if _, ok := c.Lookup("/a", 10, mtime); ok {
+		t.Error("stale entry still found")
+	}
+	if c.Len() != 1 {
+		t.Errorf("got %d entries, want 1", c.Len())
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	mtime := time.Unix(1587085387, 42)
+	c := New("sha1")
+	c.Store("/a", 10, mtime, "hash-a")
+	c.Store("/b\nc", 0, mtime, "hash-b")
+
+	var buf bytes.Buffer
+	if err := c.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	saved := buf.String()
+
+	loaded, err := Load(strings.NewReader(saved), "sha1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 2 {
+		t.Errorf("loaded %d entries, want 2", loaded.Len())
+	}
+	for path, want := range map[string]string{"/a": "hash-a", "/b\nc": "hash-b"} {
+		size := int64(10)
+		if want == "hash-b" {
+			size = 0
+		}
+		if got, ok := loaded.Lookup(path, size, mtime); !ok || got != want {
+			t.Errorf("%q: got %q, %v, want %q", path, got, ok, want)
+		}
+	}
+
+	// Hashes of another algorithm are of no use
+	other, err := Load(strings.NewReader(saved), "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Len() != 0 {
+		t.Errorf("loaded %d entries of another algorithm", other.Len())
+	}
+	other.Store("/a", 10, mtime, "hash") // Still usable
+
+	if _, err := Load(strings.NewReader("{"), "sha1"); err == nil {
+		t.Error("no error for a broken cache")
+	}
+}
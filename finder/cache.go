@@ -0,0 +1,28 @@
+package finder
+
+import (
+	"time"
+
+	"github.com/caelifer/dups/node"
+)
+
+// HashCache stores content hashes of files between runs, see Finder.Cache.
+// Implementations must be safe for concurrent use.
+type HashCache interface {
+	// Lookup returns the stored hash, unless the file size or modification time changed.
+	Lookup(path string, size int64, mtime time.Time) (string, bool)
+	// Store puts the hash of the file to the cache.
+	Store(path string, size int64, mtime time.Time, hash string)
+}
+
+// Set node hash from the cache, reporting whether it was there
+func (f *Finder) cachedHash(n *node.Node) bool {
+	if f.Cache == nil || len(f.Digests) > 0 {
+		return false
+	}
+	hash, ok := f.Cache.Lookup(n.Path, n.Size, n.ModTime)
+	if ok {
+		n.Hash = hash
+	}
+	return ok
+}
@@ -1,15 +1,21 @@
 package finder
 
 import (
+	"encoding/json"
 	"fmt"
+	"unicode/utf8"
 
 	"github.com/caelifer/dups/node"
 )
 
 // Dup type describes found duplicate file
 type Dup struct {
-	*node.Node     // Embed Node type Go type "inheritance"
-	Count      int // Number of identical copies for the hash
+	*node.Node          // Embed Node type Go type "inheritance"
+	Count       int     // Number of identical copies for the hash
+	Split       int     // Index of the group among groups with the same hash, see CompareBytes
+	Wasted      int64   // Space wasted by the group, on disk with UseDiskSize
+	Ratio       float64 // Estimated compression ratio of the content (optional)
+	WastedShare float64 // Percentage of total wasted space taken by the group (optional)
 }
 
 // Value implements mapreduce.Value interface
@@ -17,7 +23,111 @@ func (d Dup) Value() interface{} {
 	return d
 }
 
-// Pretty printer for the report
+// Group identifies the group of identical files the duplicate belongs to. It is the hash,
+// suffixed with the split index for groups with the same hash but different content.
+func (d Dup) Group() string {
+	if d.Split == 0 {
+		return d.Hash
+	}
+	return fmt.Sprintf("%s-%d", d.Hash, d.Split)
+}
+
+// JSON form of Dup
+type jsonDup struct {
+	Hash        string  `json:"hash"`
+	Split       int     `json:"split,omitempty"`
+	Count       int     `json:"count"`
+	Size        int64   `json:"size"`
+	Path        string  `json:"path"`
+	PathBytes   []byte  `json:"path_bytes,omitempty"` // Raw path if it's not valid UTF-8
+	Ratio       float64 `json:"ratio,omitempty"`
+	WastedShare float64 `json:"wasted_pct,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler interface. Optional fields are omitted when unset.
+// JSON strings can't hold arbitrary bytes, so paths which aren't valid UTF-8 are also
+// written as base64-encoded path_bytes.
+func (d Dup) MarshalJSON() ([]byte, error) {
+	j := jsonDup{d.Hash, d.Split, d.Count, d.Size, d.Path, nil, d.Ratio, d.WastedShare}
+	if !utf8.ValidString(d.Path) {
+		j.PathBytes = []byte(d.Path)
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface, preferring path_bytes over path.
+func (d *Dup) UnmarshalJSON(data []byte) error {
+	var j jsonDup
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	path := j.Path
+	if j.PathBytes != nil {
+		path = string(j.PathBytes)
+	}
+	*d = Dup{
+		Node:        &node.Node{Hash: j.Hash, Size: j.Size, Path: path},
+		Split:       j.Split,
+		Count:       j.Count,
+		Ratio:       j.Ratio,
+		WastedShare: j.WastedShare,
+	}
+	return nil
+}
+
+// String formats Dup for the text report as hash:count:size:"path" followed by optional
+// :ratio and :share% columns. Only the path may contain colons and it is always Go-quoted,
+// so the first three colons and the closing quote delimit the fields unambiguously.
 func (d Dup) String() string {
-	return fmt.Sprintf("%s:%d:%d:%q", d.Hash, d.Count, d.Size, d.Path)
+	s := fmt.Sprintf("%s:%d:%d:%q", d.Hash, d.Count, d.Size, d.Path)
+	if d.Ratio > 0 {
+		// Optional column
+		s += fmt.Sprintf(":%.2f", d.Ratio)
+	}
+	if d.WastedShare > 0 {
+		// Optional column, marked to tell it from the ratio
+		s += fmt.Sprintf(":%.2f%%", d.WastedShare)
+	}
+	return s
+}
+
+// DupSet is a group of identical files
+type DupSet struct {
+	Hash  string   // Content hash
+	Split int      // Index of the group among groups with the same hash, see Dup.Split
+	Size  int64    // File size
+	Count int      // Number of copies, more than len(Paths) if limited by MaxGroupMembers
+	Paths []string // Paths of the copies
+}
+
+// DuplicateSets finds all duplicate files in the provided paths like AllDuplicateFiles,
+// but emits each group of identical files as a whole.
+func (f *Finder) DuplicateSets(paths []string) <-chan DupSet {
+	out := make(chan DupSet)
+
+	go func() {
+		defer close(out)
+
+		// Members of each group are reported consecutively
+		var cur *DupSet
+		for x := range f.AllDuplicateFiles(paths) {
+			d, ok := x.(Dup) // Type assert
+			if !ok {
+				continue // Not a duplicate, e.g. processing failure
+			}
+			if cur != nil && (cur.Hash != d.Hash || cur.Split != d.Split) {
+				out <- *cur
+				cur = nil
+			}
+			if cur == nil {
+				cur = &DupSet{Hash: d.Hash, Split: d.Split, Size: d.Size, Count: d.Count}
+			}
+			cur.Paths = append(cur.Paths, d.Path)
+		}
+		if cur != nil {
+			out <- *cur
+		}
+	}()
+
+	return out
 }
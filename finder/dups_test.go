@@ -0,0 +1,136 @@
+package finder
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/caelifer/dups/node"
+)
+
+func TestDupJSON(t *testing.T) {
+	for _, path := range []string{"plain", "ünïcode", "bad\xff\xfename", "tab\tnew\nline"} {
+		d := Dup{Node: &node.Node{Hash: "h", Size: 3, Path: path}, Count: 2, Split: 1}
+
+		data, err := json.Marshal(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hasBytes := strings.Contains(string(data), `"path_bytes"`); hasBytes == utf8.ValidString(path) {
+			t.Errorf("%q: path_bytes present %v in %s", path, hasBytes, data)
+		}
+
+		var got Dup
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Path != path || got.Hash != d.Hash || got.Size != d.Size || got.Count != d.Count || got.Split != d.Split {
+			t.Errorf("%q: round trip gave %+v %+v", path, got, got.Node)
+		}
+	}
+}
+
+// Collect sets found by f in paths, ordered by their members
+func collectSets(t *testing.T, f *Finder, paths ...string) []DupSet {
+	t.Helper()
+	done := make(chan []DupSet)
+	go func() {
+		var sets []DupSet
+		for s := range f.DuplicateSets(paths) {
+			sets = append(sets, s)
+		}
+		done <- sets
+	}()
+
+	select {
+	case sets := <-done:
+		for _, s := range sets {
+			sort.Strings(s.Paths)
+		}
+		sort.Slice(sets, func(i, j int) bool { return sets[i].Paths[0] < sets[j].Paths[0] })
+		return sets
+	case <-time.After(30 * time.Second):
+		t.Fatal("scan didn't finish")
+		return nil
+	}
+}
+
+func TestDuplicateSets(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a1": "aaaa", "d/a2": "aaaa", "e/a3": "aaaa",
+		"b1": "bb", "d/b2": "bb",
+		"c": "unique",
+	})
+
+	f := New(2)
+	f.Sink = NewTextSink(io.Discard)
+	f.CompareBytes = true
+
+	sets := collectSets(t, f, root)
+	want := []struct {
+		size  int64
+		paths []string
+	}{
+		{4, []string{"a1", "d/a2", "e/a3"}},
+		{2, []string{"b1", "d/b2"}},
+	}
+	if len(sets) != len(want) {
+		t.Fatalf("got %d sets %+v, want %d", len(sets), sets, len(want))
+	}
+	for i, s := range sets {
+		var paths []string
+		for _, p := range s.Paths {
+			rel, _ := filepath.Rel(root, p)
+			paths = append(paths, filepath.ToSlash(rel))
+		}
+		if strings.Join(paths, ",") != strings.Join(want[i].paths, ",") || s.Size != want[i].size || s.Count != len(want[i].paths) {
+			t.Errorf("set %d: got %d copies of size %d %q, want %d of size %d %q",
+				i, s.Count, s.Size, paths, len(want[i].paths), want[i].size, want[i].paths)
+		}
+	}
+	if sets[0].Hash == sets[1].Hash {
+		t.Errorf("sets share hash %q", sets[0].Hash)
+	}
+}
+
+func TestDuplicateSetsSplit(t *testing.T) {
+	root := writeTree(t, map[string]string{"a1": "aaaa", "a2": "aaaa", "b1": "bbbb", "b2": "bbbb"})
+
+	// Same hash, different content
+	f := New(2)
+	f.Sink = NewTextSink(io.Discard)
+	f.Cache = collidingCache{}
+	f.CompareBytes = true
+
+	sets := collectSets(t, f, root)
+	if len(sets) != 2 {
+		t.Fatalf("got %d sets %+v, want 2", len(sets), sets)
+	}
+	for i, prefix := range []string{"a", "b"} {
+		s := sets[i]
+		if s.Count != 2 || len(s.Paths) != 2 || !strings.HasPrefix(filepath.Base(s.Paths[0]), prefix) || !strings.HasPrefix(filepath.Base(s.Paths[1]), prefix) {
+			t.Errorf("set %d: got %+v, want %s files", i, s, prefix)
+		}
+	}
+	if sets[0].Hash != sets[1].Hash || sets[0].Split == sets[1].Split {
+		t.Errorf("got sets %+v and %+v, want the same hash split apart", sets[0], sets[1])
+	}
+}
+
+func TestDuplicateSetsLimited(t *testing.T) {
+	root := writeTree(t, map[string]string{"a": "same", "b": "same", "c": "same", "d": "same"})
+
+	f := New(2)
+	f.Sink = NewTextSink(io.Discard)
+	f.MaxGroupMembers = 2
+
+	sets := collectSets(t, f, root)
+	if len(sets) != 1 || sets[0].Count != 4 || len(sets[0].Paths) != 2 {
+		t.Errorf("got %+v, want a set of 4 copies listing 2", sets)
+	}
+}
@@ -0,0 +1,112 @@
+package finder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/caelifer/dups/fstree"
+	"github.com/caelifer/dups/node"
+)
+
+// EmptyDirs finds directories without any entries in the provided paths, e.g. left
+// behind by removal of duplicates. If recursive is set, directories containing nothing
+// but other empty directories are reported too. Directories whose content is not fully
+// known, because something in them could not be read or they are on another filesystem
+// with OneFileSystem set, are never reported. Exclude patterns and depth limits are
+// ignored, so that nothing skipped makes a directory look empty. It returns sorted paths.
+func (f *Finder) EmptyDirs(paths []string, recursive bool) []string {
+	f.startScan(context.Background())
+	sink := f.sink()
+
+	var mu sync.Mutex
+	dirs := make(map[string]bool)    // All seen directories
+	children := make(map[string]int) // Number of entries by directory
+	content := make(map[string]bool) // Paths which keep their parent directories
+	unknown := make(map[string]bool) // Paths whose content is not known
+
+	opts := f.walkOptions()
+	opts.Skip = nil
+	opts.MaxDepth = fstree.NoDepthLimit
+	opts.ResumeFrom = ""
+	opts.OnError = func(path string, err error) error {
+		atomic.AddUint64(&f.totalSkipped, 1)
+		if f.Verbose {
+			sink.Warning(err.Error())
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		unknown[filepath.Clean(path)] = true
+		return nil
+	}
+
+	for _, p := range paths {
+		root := filepath.Clean(p)
+		var rootDev uint64
+
+		err := fstree.WalkWithOptions(f.scheduler, p, opts, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				sink.Warning(err.Error())
+				return nil
+			}
+			f.current.Store(path)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if path != root {
+				children[filepath.Dir(path)]++
+			}
+			if !info.IsDir() {
+				atomic.AddUint64(&f.totalFiles, 1)
+				content[path] = true
+				return nil
+			}
+			atomic.AddUint64(&f.totalDirs, 1)
+			dirs[path] = true
+
+			// Mount points are not walked into
+			dev := node.New(path, info).Dev
+			if path == root {
+				rootDev = dev
+			} else if f.OneFileSystem && dev != rootDev {
+				unknown[path] = true
+			}
+			return nil
+		})
+
+		// Go on with other paths
+		if err != nil {
+			sink.Warning(err.Error())
+		}
+	}
+
+	// Whatever is or might be inside keeps all directories above it
+	keep := make(map[string]bool)
+	for p := range unknown {
+		keep[p] = true
+	}
+	if recursive {
+		for p := range content {
+			keep[p] = true
+		}
+	}
+	for p := range keep {
+		for d := filepath.Dir(p); dirs[d] && !keep[d]; d = filepath.Dir(d) {
+			keep[d] = true
+		}
+	}
+
+	var res []string
+	for d := range dirs {
+		if keep[d] || children[d] > 0 && !recursive {
+			continue
+		}
+		res = append(res, d)
+	}
+	sort.Strings(res)
+	return res
+}
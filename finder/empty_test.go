@@ -0,0 +1,55 @@
+package finder
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEmptyDirs(t *testing.T) {
+	root := writeTree(t, map[string]string{"e/f": "file", "g/file": "file"})
+	for _, dir := range []string{"a", "b/c/d", "b/x", "g/h"} {
+		if err := os.MkdirAll(filepath.Join(root, filepath.FromSlash(dir)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		recursive bool
+		want      []string
+	}{
+		{false, []string{"a", "b/c/d", "b/x", "g/h"}},
+		{true, []string{"a", "b", "b/c", "b/c/d", "b/x", "g/h"}},
+	}
+	for _, tt := range tests {
+		f := New(2)
+		f.Sink = NewTextSink(io.Discard)
+
+		var got []string
+		for _, d := range f.EmptyDirs([]string{root}, tt.recursive) {
+			rel, _ := filepath.Rel(root, d)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("recursive %v: got %q, want %q", tt.recursive, got, tt.want)
+		}
+	}
+}
+
+func TestEmptyDirsRoot(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "a", "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Scanned directory with only empty directories inside is empty itself
+	f := New(2)
+	f.Sink = NewTextSink(io.Discard)
+	got := f.EmptyDirs([]string{root}, true)
+	want := []string{root, filepath.Join(root, "a"), filepath.Join(root, "a", "b")}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,54 @@
+package finder
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Pattern matches paths excluded from the scan.
+type Pattern struct {
+	glob string         // Shell pattern, see filepath.Match
+	re   *regexp.Regexp // Regular expression, if glob is empty
+}
+
+// ParsePattern parses exclude pattern. Patterns prefixed with "re:" are regular expressions
+// matched against the whole path. Others are shell patterns (see filepath.Match) matched
+// against the base name, or against the whole path if they contain a path separator.
+func ParsePattern(s string) (Pattern, error) {
+	if strings.HasPrefix(s, "re:") {
+		re, err := regexp.Compile(strings.TrimPrefix(s, "re:"))
+		return Pattern{re: re}, err
+	}
+
+	// Check syntax up front
+	if _, err := filepath.Match(s, ""); err != nil {
+		return Pattern{}, err
+	}
+	return Pattern{glob: s}, nil
+}
+
+// Match reports whether path matches the pattern.
+func (p Pattern) Match(path string) bool {
+	if p.re != nil {
+		return p.re.MatchString(path)
+	}
+
+	name := filepath.Base(path)
+	if strings.ContainsRune(p.glob, os.PathSeparator) {
+		name = path
+	}
+	ok, _ := filepath.Match(p.glob, name)
+	return ok
+}
+
+// Check whether path matches any of the exclude patterns
+func (f *Finder) excluded(path string) bool {
+	for _, p := range f.Exclude {
+		if p.Match(path) {
+			return true
+		}
+	}
+	return false
+}
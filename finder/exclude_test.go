@@ -0,0 +1,74 @@
+package finder
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"*.tmp", "/a/b/x.tmp", true},
+		{"*.tmp", "/a/b.tmp/x", false},
+		{"node_modules", "/src/node_modules", true},
+		{"node_modules", "/src/node_modules2", false},
+		{"/a/*/c", "/a/b/c", true},
+		{"/a/*/c", "/a/b/d/c", false},
+		{"re:/cache/", "/home/u/.cache/x", false},
+		{"re:/\\.cache/", "/home/u/.cache/x", true},
+		{"re:^/tmp", "/var/tmp", false},
+	}
+
+	for _, tt := range tests {
+		p, err := ParsePattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.pattern, err)
+		}
+		if got := p.Match(tt.path); got != tt.want {
+			t.Errorf("%q matching %q = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"[", "re:("} {
+		if _, err := ParsePattern(bad); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
+
+func TestExcludedDirPruned(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a":                "same",
+		"keep/b":           "same",
+		"skip/c":           "same",
+		"skip/deep/d":      "same",
+		"skip/deep/more/e": "same",
+	})
+	pat, err := ParsePattern("skip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := new(progressRecorder)
+	f := New(2)
+	f.Sink = rec
+	f.Exclude = []Pattern{pat}
+
+	dups := collect(t, f, root)
+	if len(dups) != 2 {
+		t.Errorf("found %d dups %v, want 2", len(dups), dups)
+	}
+
+	// Neither the excluded directory nor anything below it is visited
+	if s := f.Summary(); s.Dirs != 2 || s.Files != 2 {
+		t.Errorf("visited %d dirs and %d files, want 2 and 2", s.Dirs, s.Files)
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.debugs) != 1 || rec.debugs[0] != "excluded \""+filepath.Join(root, "skip")+"\"" {
+		t.Errorf("got debug messages %q, want only the excluded directory", rec.debugs)
+	}
+
+}
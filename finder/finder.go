@@ -1,14 +1,18 @@
 package finder
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/caelifer/scheduler"
+	"golang.org/x/text/unicode/norm"
 
 	"github.com/caelifer/dups/fstree"
 	"github.com/caelifer/dups/mapreduce"
@@ -17,47 +21,498 @@ import (
 
 // Finder
 type Finder struct {
+	// MaxBytesHashed caps the number of bytes read while hashing. When the budget
+	// is exhausted the scan is cancelled and partial results are reported. Zero
+	// means no limit.
+	MaxBytesHashed int64
+
+	// CompressionSample enables estimation of the compression ratio for each duplicate
+	// group by compressing up to CompressionSample bytes of the group's first file.
+	// Zero disables estimation.
+	CompressionSample int64
+
+	// PrefixHashSize enables a quick grouping stage before full hashing: only first
+	// PrefixHashSize bytes of same size files are hashed, and files with unique prefixes
+	// are never read in full. Zero disables the stage.
+	PrefixHashSize int64
+
+	// SampleStride enables approximate hashing of files larger than SampleStride: only
+	// blocks of SampleSize bytes at every SampleStride bytes offset are read. Reported
+	// hashes are then fingerprints of the samples. Zero means files are hashed in full.
+	SampleStride int64
+	SampleSize   int64
+
+	// VerifySampled confirms files grouped by sampled hashes with the full hash, so only
+	// the reading of files with unique samples is saved.
+	VerifySampled bool
+
+	// Digests lists extra digest algorithms (see node.DigestNames) computed along with
+	// content hash in the same read pass and stored in node.Node.Digests.
+	Digests []string
+
+	// DetectContentTypes enables breakdown of wasted space by content type of the
+	// duplicates, see ContentTypes().
+	DetectContentTypes bool
+
+	// IgnoreHardlinks makes hard links to the same file considered a single file, found
+	// under the first seen path, since removing some of them frees no space.
+	IgnoreHardlinks bool
+
+	// MinSize and MaxSize limit deduplication to files of sizes within the range,
+	// inclusive. Zero MaxSize means no upper limit.
+	MinSize int64
+	MaxSize int64
+
+	// CompareBytes makes files with the same hash compared byte for byte before they
+	// are reported as duplicates. Files with different content are split into separate
+	// groups, and files changed since hashed are dropped with a warning.
+	CompareBytes bool
+
+	// CrossRootsOnly limits reported duplicate groups to the ones with members found
+	// under at least two of the scanned paths, e.g. to check that everything in one
+	// backup is already in another.
+	CrossRootsOnly bool
+
+	// NoRecurse makes given paths processed as is, without walking directories among
+	// them, which are only counted. Use it for lists of files enumerated elsewhere.
+	NoRecurse bool
+
+	// IncludeEmpty makes zero-length files deduplicated too. They are all identical,
+	// yet waste no space, so by default duplicate searches skip them. Listings, like
+	// ListFiles, AllFiles and MissingFiles, always include them.
+	IncludeEmpty bool
+
+	// MaxDepth limits the walk to that many levels below each scanned path: 0 means the
+	// path itself only, 1 the path and its entries, and so on. Deeper entries are not
+	// even read. Negative values mean no limit, which is the default set by New.
+	MaxDepth int
+
+	// OneFileSystem keeps the walk from descending into directories mounted from other
+	// filesystems than the scanned paths, like find -xdev.
+	OneFileSystem bool
+
+	// MaxHashDepth limits deduplication to files within that many levels below each
+	// scanned path; files directly in it are at level 1. Deeper files are still walked
+	// and counted. Zero means no limit.
+	MaxHashDepth int
+
+	// MaxGroupMembers limits how many members of a single duplicate group are kept
+	// by the final grouping stage and reported. Larger groups are summarized: only the
+	// first members are reported, while the count and wasted space account for all of
+	// them. Earlier stages still hold all files of the same size or hash while grouping
+	// them, so peak memory use of the scan is not bounded. Zero means no limit.
+	MaxGroupMembers int
+
+	// MaxInFlight limits how many files may be queued for hashing at once. Once the
+	// limit is reached, hashing stages stop consuming their input until some files
+	// are done, so memory use does not grow with the size of the tree. Such bounded
+	// hashing runs on up to MaxInFlight goroutines per stage instead of the scheduler
+	// workers, which may all be busy walking. Zero means no limit.
+	MaxInFlight int
+
+	// ReduceShards is the number of concurrent reducers used by grouping stages.
+	// Values less than 2 use a single reducer.
+	ReduceShards int
+
+	// TimeStages makes AllDuplicateFiles record activity time and throughput of each
+	// pipeline stage, reported in Summary.Stages.
+	TimeStages bool
+
+	// ReportFailures makes the output channel carry *mapreduce.Failure values for
+	// paths which could not be read or processed (e.g. hashed) instead of silently
+	// dropping them. It's read once the scan is started.
+	ReportFailures bool
+
+	// NormalizeUnicode makes paths keyed in Unicode NFC form, so the same file seen
+	// via differently normalized names (e.g. on APFS) is not reported as its own
+	// duplicate.
+	NormalizeUnicode bool
+
+	// Exclude lists patterns of paths skipped by the scan. Matching directories are not
+	// read at all.
+	Exclude []Pattern
+
+	// OnlyExtensions restricts the scan to files with listed extensions (case-insensitive,
+	// with or without the leading dot). Empty list allows all files.
+	OnlyExtensions []string
+
+	// Cache provides full content hashes of files unchanged since they were stored,
+	// which are then not read again. Newly calculated hashes are stored to it. It is
+	// not used with extra Digests. Nil means no caching.
+	Cache HashCache
+
+	// Sink receives progress updates, warnings and final stats. Nil means human
+	// readable text on stderr.
+	Sink ProgressSink
+
+	// Symlinks defines how symbolic links are handled during the walk.
+	Symlinks fstree.SymlinkPolicy
+
+	// UseDiskSize makes wasted space accounted by space allocated on disk instead of
+	// apparent file size. This gives truer estimate for sparse and small files.
+	UseDiskSize bool
+
+	// WalkOrder defines directory traversal order. Ordered traversal reads
+	// directories sequentially.
+	WalkOrder fstree.Order
+
+	// ResumeFrom skips all paths ordered before it, see fstree.Options.
+	ResumeFrom string
+
+	// Verbose makes each path which can't be read during the walk reported as a
+	// warning. By default they are only counted and summarized once the walk is done,
+	// each one being reported to the sink as a debug message.
+	Verbose bool
+
 	// Work Queue
 	scheduler scheduler.Scheduler
 
+	// Cancellation
+	ctx    context.Context
+	cancel context.CancelFunc
+	parent context.Context // Given by the caller
+
+	// Seen files with several hard links
+	linksMu sync.Mutex
+	links   map[[2]uint64]struct{}
+
+	// Wasted space by content type
+	wastedByType map[string]uint64
+
+	// Path currently being processed
+	current atomic.Value
+
+	// Pipeline stage timers, see TimeStages
+	timers []*mapreduce.StageTimer
+
 	// Stats
 	totalDirs        uint64
 	totalFiles       uint64
 	totalCopies      uint64
 	totalWastedSpace uint64
+	totalBytesHashed uint64
+	totalSkipped     uint64
+	budgetExceeded   uint32
 	totalTime        time.Duration
 }
 
+// New creates Finder running its work on nWorkers workers. Non-positive nWorkers
+// defaults to the number of CPUs.
 func New(nWorkers int) *Finder {
-	return &Finder{scheduler: scheduler.New(nWorkers)}
+	return NewWithOptions(Options{Workers: nWorkers})
+}
+
+// Options configure the work queue of a Finder, see NewWithOptions.
+type Options struct {
+	// Workers is the number of parallel jobs. Zero picks the number of CPUs.
+	Workers int
+
+	// QueueDepth is the number of jobs accepted ahead of busy workers. Zero hands
+	// each job directly over to a free worker.
+	QueueDepth int
+}
+
+// NewWithOptions creates Finder with the work queue configured by opts.
+func NewWithOptions(opts Options) *Finder {
+	nWorkers := opts.Workers
+	if nWorkers <= 0 {
+		nWorkers = defaultPoolSize()
+	}
+	f := &Finder{MaxDepth: fstree.NoDepthLimit}
+	f.scheduler = newSafeScheduler(scheduler.New(nWorkers), nWorkers, f.warn)
+	if opts.QueueDepth > 0 {
+		f.scheduler = newQueuedScheduler(f.scheduler, opts.QueueDepth)
+	}
+	return f
+}
+
+// NewWithScheduler creates Finder running its work on sched. If sched is nil or panics,
+// work falls back to a bounded goroutine pool.
+func NewWithScheduler(sched scheduler.Scheduler) *Finder {
+	f := &Finder{MaxDepth: fstree.NoDepthLimit}
+	f.scheduler = newSafeScheduler(sched, defaultPoolSize(), f.warn)
+	return f
 }
 
 func (f *Finder) SetTimeSpent(d time.Duration) {
 	f.totalTime = d
 }
 
-func (f Finder) Stats() string {
-	// Stats report
-	return fmt.Sprintf("examined %d files in %d directories [%s], found %d dups, total wasted space %.2fGiB",
-		f.totalFiles, f.totalDirs, f.totalTime, f.totalCopies, float64(f.totalWastedSpace)/(1024*1024*1024))
+func (f *Finder) Stats() string {
+	return f.Summary().String()
+}
+
+// Summary returns statistics of the last scan.
+func (f *Finder) Summary() Summary {
+	return Summary{
+		Files:          atomic.LoadUint64(&f.totalFiles),
+		Dirs:           atomic.LoadUint64(&f.totalDirs),
+		Copies:         atomic.LoadUint64(&f.totalCopies),
+		WastedSpace:    atomic.LoadUint64(&f.totalWastedSpace),
+		BytesHashed:    atomic.LoadUint64(&f.totalBytesHashed),
+		Skipped:        atomic.LoadUint64(&f.totalSkipped),
+		Elapsed:        f.totalTime,
+		MaxBytesHashed: f.MaxBytesHashed,
+		BudgetExceeded: f.BudgetExceeded(),
+		Stages:         f.stageStats(),
+	}
+}
+
+// StatsStruct returns totals of the last scan. They are the Files, Dirs, Copies,
+// WastedSpace and Elapsed fields of Summary, which holds the full statistics.
+func (f *Finder) StatsStruct() RunStats {
+	sum := f.Summary()
+	return RunStats{
+		TotalDirs:        sum.Dirs,
+		TotalFiles:       sum.Files,
+		TotalCopies:      sum.Copies,
+		TotalWastedSpace: sum.WastedSpace,
+		TotalTime:        sum.Elapsed,
+	}
+}
+
+// Collect timing of pipeline stages, if enabled
+func (f *Finder) stageStats() []mapreduce.StageStats {
+	var stats []mapreduce.StageStats
+	for _, t := range f.timers {
+		stats = append(stats, t.Stats())
+	}
+	return stats
+}
+
+// ReportStats sends scan statistics to the sink.
+func (f *Finder) ReportStats() {
+	f.sink().Stats(f.Summary())
+}
+
+// Reset per-scan state and set up cancellation. Each scan starts from scratch, so that
+// a Finder can be reused for several scans; Summary describes the last one.
+func (f *Finder) startScan(ctx context.Context) {
+	f.parent = ctx
+	f.ctx, f.cancel = context.WithCancel(ctx)
+
+	f.linksMu.Lock()
+	f.links = nil
+	f.linksMu.Unlock()
+
+	atomic.StoreUint64(&f.totalDirs, 0)
+	atomic.StoreUint64(&f.totalFiles, 0)
+	atomic.StoreUint64(&f.totalCopies, 0)
+	atomic.StoreUint64(&f.totalWastedSpace, 0)
+	atomic.StoreUint64(&f.totalBytesHashed, 0)
+	atomic.StoreUint64(&f.totalSkipped, 0)
+	atomic.StoreUint32(&f.budgetExceeded, 0)
+}
+
+// Get configured sink or the default one
+func (f *Finder) sink() ProgressSink {
+	if f.Sink == nil {
+		f.Sink = NewTextSink(os.Stderr)
+	}
+	return f.Sink
+}
+
+// Report a warning to the sink
+func (f *Finder) warn(msg string) {
+	f.sink().Warning(msg)
+}
+
+// Progress returns a snapshot of the running scan. It only reads counters, so it's cheap
+// enough to be polled.
+func (f *Finder) Progress() Progress {
+	current, _ := f.current.Load().(string)
+	return Progress{
+		Files:       atomic.LoadUint64(&f.totalFiles),
+		Dirs:        atomic.LoadUint64(&f.totalDirs),
+		BytesHashed: atomic.LoadUint64(&f.totalBytesHashed),
+		Current:     current,
+	}
+}
+
+// StartHeartbeat sends current counts and the path being processed to the sink every
+// interval, so that long scans show they are alive. Call returned function to stop it;
+// no updates are sent once it returns.
+func (f *Finder) StartHeartbeat(interval time.Duration) (stop func()) {
+	sink := f.sink()
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-ticker.C:
+				sink.Progress(f.Progress())
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-stopped
+	}
+}
+
+// ContentTypes returns wasted space in bytes by detected content type. It is only
+// available when DetectContentTypes is set and the scan is finished.
+func (f *Finder) ContentTypes() map[string]uint64 {
+	return f.wastedByType
 }
 
+// BudgetExceeded reports whether the scan was stopped because MaxBytesHashed was reached.
+func (f *Finder) BudgetExceeded() bool {
+	return atomic.LoadUint32(&f.budgetExceeded) == 1
+}
+
+// AllDuplicateFiles finds all duplicate files in the provided paths. It returns a channel
+// of Dup values. All pipeline stages run concurrently: a file is sent to be hashed as soon
+// as another file of the same size is found, so hashing overlaps with the directory walk.
 func (f *Finder) AllDuplicateFiles(paths []string) <-chan mapreduce.Value {
+	return f.AllDuplicateFilesContext(context.Background(), paths)
+}
+
+// AllDuplicateFilesContext is like AllDuplicateFiles, but stops the scan once ctx is done:
+// no new directories are read and no new files are hashed, while the work in progress is
+// drained and duplicates found so far are reported. Once the channel is closed, Err tells
+// whether the scan was stopped.
+func (f *Finder) AllDuplicateFilesContext(ctx context.Context, paths []string) <-chan mapreduce.Value {
+	f.startScan(ctx)
+
 	// Build a processing pipeline
+	f.timers = nil
+	stages := []mapreduce.MapReducePair{
+		f.timed("walk", mapreduce.MapReducePair{
+			Map:    f.makeNodeMap(paths),
+			Reduce: mapreduce.Sharded(f.ReduceShards, mapreduce.FilterOutDuplicates),
+		}),
+		f.timed("size", mapreduce.MapReducePair{
+			Map:    f.makeFileSizeMap(f.inDedupScope),
+			Reduce: mapreduce.Sharded(f.ReduceShards, mapreduce.FilterOutUniques),
+		}),
+	}
+	if f.PrefixHashSize > 0 {
+		// Drop files differing early on
+		stages = append(stages, f.timed("prefix-hash", mapreduce.MapReducePair{
+			Map:    f.makeFileHashMap(prefixHash),
+			Reduce: mapreduce.Sharded(f.ReduceShards, mapreduce.FilterOutUniques),
+		}))
+	}
+	if f.SampleStride > 0 {
+		// Cheap approximate grouping first
+		stages = append(stages, f.timed("sampled-hash", mapreduce.MapReducePair{
+			Map:    f.makeFileHashMap(sampledHash),
+			Reduce: mapreduce.Sharded(f.ReduceShards, mapreduce.FilterOutUniques),
+		}))
+	}
+	if f.SampleStride == 0 || f.VerifySampled {
+		stages = append(stages, f.timed("full-hash", mapreduce.MapReducePair{
+			Map:    f.makeFileHashMap(fullHash),
+			Reduce: mapreduce.Sharded(f.ReduceShards, mapreduce.FilterOutUniques),
+		}))
+	}
+	stages = append(stages, f.timed("group", mapreduce.MapReducePair{
+		Map:    f.mapDups(),
+		Reduce: f.reduceDups(),
+	}))
+
+	return mapreduce.Pipeline(stages...)
+}
+
+// Err returns the error of the context the last scan was run with, see
+// AllDuplicateFilesContext. Once the result channel is closed, non-nil Err means the
+// scan was stopped and its results are partial.
+func (f *Finder) Err() error {
+	if f.parent == nil {
+		return nil
+	}
+	return f.parent.Err()
+}
+
+// ForEachDuplicate calls fn for each duplicate found in paths. If fn returns an error,
+// the scan is stopped, and once the work in progress is drained, the error is returned.
+func (f *Finder) ForEachDuplicate(paths []string, fn func(d Dup) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var err error
+	for x := range f.AllDuplicateFilesContext(ctx, paths) {
+		d, ok := x.(Dup) // Type assert
+		if !ok || err != nil {
+			continue // Not a duplicate or draining
+		}
+		if err = fn(d); err != nil {
+			cancel()
+		}
+	}
+	return err
+}
+
+// Most errors AllDuplicateFilesWithErrors holds back, the rest are only counted
+const maxHeldErrors = 1000
+
+// AllDuplicateFilesWithErrors is like AllDuplicateFiles, but also reports paths which
+// could not be read, stat-ed or hashed, regardless of ReportFailures. Errors are held
+// back until all duplicates are sent, so the error channel must be drained after the Dup
+// channel is closed, or the feeding goroutine leaks. Only the first 1000 errors are held,
+// the rest are summed up in a final error. Both channels are closed once the scan is done.
+func (f *Finder) AllDuplicateFilesWithErrors(paths []string) (<-chan Dup, <-chan error) {
+	// Stages pick the setting up as they are built
+	saved := f.ReportFailures
+	f.ReportFailures = true
+	results := f.AllDuplicateFiles(paths)
+	f.ReportFailures = saved
+
+	dups := make(chan Dup)
+	errs := make(chan error)
+	go func() {
+		var (
+			failures []error
+			dropped  int
+		)
+		for x := range results {
+			if fl, ok := x.(*mapreduce.Failure); ok {
+				if len(failures) < maxHeldErrors {
+					failures = append(failures, fl.Err())
+				} else {
+					dropped++
+				}
+				continue
+			}
+			dups <- x.(Dup) // Type assert
+		}
+		close(dups)
+
+		for _, err := range failures {
+			errs <- err
+		}
+		if dropped > 0 {
+			errs <- fmt.Errorf("%d more errors not reported", dropped)
+		}
+		close(errs)
+	}()
+	return dups, errs
+}
+
+// AllFiles hashes all regular files found in paths. If wanted is not nil, only files
+// of sizes for which it returns true are hashed. It returns a channel of *node.Node values.
+func (f *Finder) AllFiles(paths []string, wanted func(size int64) bool) <-chan mapreduce.Value {
+	f.startScan(context.Background())
+
 	return mapreduce.Pipeline(
 		[]mapreduce.MapReducePair{
 			{
-				f.makeNodeMap(paths),
-				mapreduce.FilterOutDuplicates,
+				Map:    f.makeNodeMap(paths),
+				Reduce: mapreduce.Sharded(f.ReduceShards, mapreduce.FilterOutDuplicates),
 			}, {
-				f.makeFileSizeMap(),
-				mapreduce.FilterOutUniques,
+				Map:    f.makeSizeFilterMap(wanted),
+				Reduce: mapreduce.PassThrough,
 			}, {
-				f.makeFileHashMap(),
-				mapreduce.FilterOutUniques,
-			}, {
-				f.mapDups(),
-				f.reduceDups(),
+				Map:    f.makeFileHashMap(fullHash),
+				Reduce: mapreduce.PassThrough,
 			},
 		}...,
 	)
@@ -65,14 +520,46 @@ func (f *Finder) AllDuplicateFiles(paths []string) <-chan mapreduce.Value {
 
 // makeNodeMap
 func (f *Finder) makeNodeMap(paths []string) mapreduce.MapFn {
+	sink := f.sink()
+	failures := f.ReportFailures
+
 	return func(out chan<- mapreduce.KeyValue, _ <-chan mapreduce.Value) {
+		opts := f.walkOptions()
+		if failures {
+			// Unreadable paths are failures too
+			onError := opts.OnError
+			opts.OnError = func(path string, err error) error {
+				out <- mapreduce.NewFailure(&node.Node{Path: path}, err)
+				return onError(path, err)
+			}
+		}
+
 		// Process all command line paths
 		for _, p := range paths {
 			// err := filepath.Walk(path_, func(path string, info os.FileInfo, err error) error {
-			err := fstree.Walk(f.scheduler, p, func(path string, info os.FileInfo, err error) error {
+			err := fstree.WalkWithOptions(f.scheduler, p, opts, func(path string, info os.FileInfo, err error) error {
 				// Handle passthroughs error
 				if err != nil {
-					log.Println("WARN", err)
+					sink.Warning(err.Error())
+					return nil
+				}
+
+				// Track progress
+				f.current.Store(path)
+
+				// Wind down quickly if cancelled
+				if f.ctx.Err() != nil {
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+
+				// Walker prunes excluded entries below, listed paths are checked here
+				if f.NoRecurse && f.excluded(path) {
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
 					return nil
 				}
 
@@ -80,78 +567,338 @@ func (f *Finder) makeNodeMap(paths []string) mapreduce.MapFn {
 				if info.IsDir() {
 					// Increase seen directory counter
 					atomic.AddUint64(&f.totalDirs, 1)
+
+					// Listed directory is not to be walked
+					if f.NoRecurse {
+						return filepath.SkipDir
+					}
 				}
 
 				// Only process simple files
-				if isRegularFile(info) {
-					size := info.Size()
+				if isRegularFile(info) && f.allowedExtension(path) {
+					// Increase seen files counter
+					atomic.AddUint64(&f.totalFiles, 1)
+
+					// Dedup key for the path
+					key := path
+					if f.NormalizeUnicode {
+						key = norm.NFC.String(path)
+					}
+
+					// Hard links to a seen file are the same file
+					n := node.New(path, info)
+					n.Root = p
+					if f.IgnoreHardlinks && !f.firstLink(n) {
+						sink.Debug(fmt.Sprintf("%q is a hard link to a seen file", path))
+						return nil
+					}
 
 					out <- mapreduce.NewKVType(
-						mapreduce.KeyTypeFromString(path),
-						&node.Node{Path: path, Size: size},
+						mapreduce.KeyTypeFromString(key),
+						n,
 					)
-
-					// Increase seen files counter
-					atomic.AddUint64(&f.totalFiles, 1)
 				}
 				return nil
 			})
 
+			// Go on with other paths
 			if err != nil {
-				log.Fatal(err)
+				sink.Warning(err.Error())
+				if failures {
+					out <- mapreduce.NewFailure(&node.Node{Path: p}, err)
+				}
+			}
+		}
+
+		// Summarize what was not reported one by one
+		if skipped := atomic.LoadUint64(&f.totalSkipped); skipped > 0 && !f.Verbose {
+			sink.Warning(fmt.Sprintf("skipped %d unreadable paths", skipped))
+		}
+	}
+}
+
+// Build walker options from Finder settings
+func (f *Finder) walkOptions() fstree.Options {
+	sink := f.sink()
+	return fstree.Options{
+		Order:         f.WalkOrder,
+		Symlinks:      f.Symlinks,
+		ResumeFrom:    f.ResumeFrom,
+		MaxDepth:      f.MaxDepth,
+		OneFileSystem: f.OneFileSystem,
+		Skip: func(path string, entry fs.DirEntry) bool {
+			// Prune excluded subtrees and files with other extensions before stat
+			if f.excluded(path) {
+				sink.Debug(fmt.Sprintf("excluded %q", path))
+				return true
+			}
+			return entry.Type().IsRegular() && !f.allowedExtension(path)
+		},
+		OnError: func(_ string, err error) error {
+			atomic.AddUint64(&f.totalSkipped, 1)
+			if f.Verbose {
+				sink.Warning(err.Error())
+			} else {
+				sink.Debug(err.Error())
+			}
+			return nil // Skip and go on
+		},
+	}
+}
+
+// ListFiles lists all regular files found in paths without hashing them. It returns
+// a channel of *node.Node values.
+func (f *Finder) ListFiles(paths []string) <-chan mapreduce.Value {
+	f.startScan(context.Background())
+
+	return mapreduce.Pipeline(
+		mapreduce.MapReducePair{
+			Map:    f.makeNodeMap(paths),
+			Reduce: mapreduce.Sharded(f.ReduceShards, mapreduce.FilterOutDuplicates),
+		},
+	)
+}
+
+// HashFiles hashes listed files without walking. Files which can't be stat'ed or aren't
+// regular are skipped. It returns a channel of *node.Node values.
+func (f *Finder) HashFiles(paths []string) <-chan mapreduce.Value {
+	f.startScan(context.Background())
+
+	return mapreduce.Pipeline(
+		[]mapreduce.MapReducePair{
+			{
+				Map:    f.makeStatMap(paths),
+				Reduce: mapreduce.Sharded(f.ReduceShards, mapreduce.FilterOutDuplicates),
+			}, {
+				Map:    f.makeFileHashMap(fullHash),
+				Reduce: mapreduce.PassThrough,
+			},
+		}...,
+	)
+}
+
+// Create nodes for listed files
+func (f *Finder) makeStatMap(paths []string) mapreduce.MapFn {
+	return func(out chan<- mapreduce.KeyValue, _ <-chan mapreduce.Value) {
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil || !isRegularFile(info) {
+				continue
+			}
+			atomic.AddUint64(&f.totalFiles, 1)
+			out <- mapreduce.NewKVType(mapreduce.KeyTypeFromString(path), node.New(path, info))
+		}
+	}
+}
+
+// Only pass nodes of wanted sizes
+func (*Finder) makeSizeFilterMap(wanted func(size int64) bool) mapreduce.MapFn {
+	return func(out chan<- mapreduce.KeyValue, in <-chan mapreduce.Value) {
+		for x := range in {
+			n := x.Value().(*node.Node) // Assert type
+			if wanted == nil || wanted(n.Size) {
+				out <- mapreduce.NewKVType(mapreduce.KeyTypeFromString(n.Path), n)
 			}
 		}
 	}
 }
 
-// Very simple function to map nodes by size
-func (*Finder) makeFileSizeMap() mapreduce.MapFn {
+// Very simple function to map nodes by size. If wanted is not nil, only nodes for which
+// it returns true are mapped.
+func (*Finder) makeFileSizeMap(wanted func(n *node.Node) bool) mapreduce.MapFn {
 	return func(out chan<- mapreduce.KeyValue, in <-chan mapreduce.Value) {
 		for x := range in {
+			// Pass failures through
+			if fl, ok := x.(*mapreduce.Failure); ok {
+				out <- fl
+				continue
+			}
 			n := x.Value().(*node.Node) // Assert type
+			if wanted != nil && !wanted(n) {
+				continue
+			}
 			out <- mapreduce.NewKVType(mapreduce.KeyTypeFromInt64(n.Size), n)
 		}
 	}
 }
 
-func (f *Finder) makeFileHashMap() mapreduce.MapFn {
+// Hashing stage kinds
+type hashMode int
+
+const (
+	fullHash    hashMode = iota // Whole content
+	prefixHash                  // First PrefixHashSize bytes
+	sampledHash                 // Strided samples, see SampleStride
+)
+
+func (f *Finder) makeFileHashMap(mode hashMode) mapreduce.MapFn {
+	sink := f.sink()
+	failures := f.ReportFailures
+
 	return func(out chan<- mapreduce.KeyValue, in <-chan mapreduce.Value) {
 		wg := new(sync.WaitGroup) // Heap
+
+		// Free hashing slots, nil if unbounded
+		var slots chan struct{}
+		if f.MaxInFlight > 0 {
+			slots = make(chan struct{}, f.MaxInFlight)
+		}
+
 		for x := range in {
+			// Pass failures through
+			if fl, ok := x.(*mapreduce.Failure); ok {
+				out <- fl
+				continue
+			}
+			n := x.Value().(*node.Node) // Assert type
+
+			// Prefix already covered the whole file
+			if mode == fullHash && f.hashedInPrefix(n) {
+				out <- mapreduce.NewKVType(mapreduce.KeyTypeFromString(n.Hash), n)
+				continue
+			}
+
+			// Unchanged since hashed by a previous run
+			if mode == fullHash && f.cachedHash(n) {
+				out <- mapreduce.NewKVType(mapreduce.KeyTypeFromString(n.Hash), n)
+				continue
+			}
+
+			// Bytes to read
+			size := n.Size
+			switch mode {
+			case prefixHash:
+				if size > f.PrefixHashSize {
+					size = f.PrefixHashSize
+				}
+			case sampledHash:
+				size = node.SampledBytes(n.Size, f.SampleStride, f.SampleSize)
+			}
+
+			// Drain input without hashing once cancelled or out of budget
+			if f.ctx.Err() != nil || !f.reserveHashBudget(size) {
+				continue
+			}
+
 			// Add to wait group
 			wg.Add(1)
+
+			// Bounded hashing runs on goroutines of its own. Scheduler workers may all be
+			// taken by upstream jobs blocked on sending to this very stage, so hashing
+			// jobs waiting for them would never free the slots.
+			if slots != nil {
+				// Wait for a free slot, holding back the input meanwhile
+				slots <- struct{}{}
+				go func(n *node.Node) {
+					defer wg.Done() // Signal done
+					defer func() { <-slots }()
+					f.hashNode(mode, n, sink, failures, out)
+				}(n)
+				continue
+			}
+
 			// Calculate hash using balancer
 			go func(n *node.Node) {
 				f.scheduler.Schedule(func() {
 					defer wg.Done() // Signal done
-					err := n.CalculateHash()
-					if err != nil {
-						// Skip files for which we failed to calculate SHA1 hash
-						// log.Printf("WARN Unable calculate SHA1 hash for %q\n", node.Path)
-						return
-					}
-					// Report result
-					out <- mapreduce.NewKVType(
-						mapreduce.KeyTypeFromString(n.Hash),
-						n,
-					)
+					f.hashNode(mode, n, sink, failures, out)
 				})
-			}(x.Value().(*node.Node))
+			}(n)
 		}
 		// Wait for all results be submitted
 		wg.Wait()
 	}
 }
 
+// Hash the node and send it out keyed by the hash
+func (f *Finder) hashNode(mode hashMode, n *node.Node, sink ProgressSink, failures bool, out chan<- mapreduce.KeyValue) {
+	f.current.Store(n.Path)
+	var err error
+	switch mode {
+	case prefixHash:
+		err = n.CalculateHashPrefix(f.PrefixHashSize)
+	case sampledHash:
+		err = n.SampleHash(f.SampleStride, f.SampleSize)
+	default:
+		err = n.CalculateDigests(f.Digests)
+	}
+	if err != nil {
+		// Skip files for which we failed to calculate hash
+		sink.Warning(err.Error())
+		if failures {
+			out <- mapreduce.NewFailure(n, err)
+		}
+		return
+	}
+	// Remember for the next run
+	if mode == fullHash && f.Cache != nil && len(f.Digests) == 0 {
+		f.Cache.Store(n.Path, n.Size, n.ModTime, n.Hash)
+	}
+
+	// Prefixes and samples only match for files of the same size
+	key := n.Hash
+	if mode != fullHash {
+		key = fmt.Sprintf("%d:%s", n.Size, n.Hash)
+	}
+
+	// Report result
+	out <- mapreduce.NewKVType(
+		mapreduce.KeyTypeFromString(key),
+		n,
+	)
+}
+
+// Wrap pipeline stage with a timer if TimeStages is set
+func (f *Finder) timed(name string, pair mapreduce.MapReducePair) mapreduce.MapReducePair {
+	if !f.TimeStages {
+		return pair
+	}
+	t := mapreduce.NewStageTimer(name)
+	f.timers = append(f.timers, t)
+	return mapreduce.Timed(pair, t)
+}
+
+// Check whether the prefix hash of the node is its final hash
+func (f *Finder) hashedInPrefix(n *node.Node) bool {
+	return n.Hash != "" && n.Size <= f.PrefixHashSize && f.SampleStride == 0 && len(f.Digests) == 0
+}
+
+// reserveHashBudget accounts for size bytes about to be hashed. If that would
+// exceed MaxBytesHashed, it cancels the scan and returns false.
+func (f *Finder) reserveHashBudget(size int64) bool {
+	if f.MaxBytesHashed <= 0 {
+		atomic.AddUint64(&f.totalBytesHashed, uint64(size))
+		return true
+	}
+
+	// Hashing stages reserve concurrently, retry until no one else got in between
+	for {
+		total := atomic.LoadUint64(&f.totalBytesHashed)
+		if total+uint64(size) > uint64(f.MaxBytesHashed) {
+			atomic.StoreUint32(&f.budgetExceeded, 1)
+			f.cancel()
+			return false
+		}
+		if atomic.CompareAndSwapUint64(&f.totalBytesHashed, total, total+uint64(size)) {
+			return true
+		}
+	}
+}
+
 // fanal map
 func (f *Finder) mapDups() mapreduce.MapFn {
 	return func(out chan<- mapreduce.KeyValue, in <-chan mapreduce.Value) {
 		for x := range in {
+			// Pass failures through
+			if fl, ok := x.(*mapreduce.Failure); ok {
+				out <- fl
+				continue
+			}
 			// Update stats
 			atomic.AddUint64(&f.totalCopies, 1)
 			n := x.Value().(*node.Node) // Type assert
 			out <- mapreduce.NewKVType(
-				mapreduce.KeyType(n.Hash),
+				mapreduce.KeyTypeFromString(n.Hash),
 				&Dup{Node: n},
 			)
 		}
@@ -160,14 +907,37 @@ func (f *Finder) mapDups() mapreduce.MapFn {
 
 // final reduce
 func (f *Finder) reduceDups() mapreduce.ReduceFn {
+	sink := f.sink()
+
 	return func(out chan<- mapreduce.Value, in <-chan mapreduce.KeyValue) {
+		f.wastedByType = make(map[string]uint64)
 		byHash := make(map[string][]Dup)
+		counts := make(map[string]int) // Real group sizes
+		roots := make(map[string]map[string]struct{})
 
 		for x := range in {
+			// Pass failures through
+			if fl, ok := x.(*mapreduce.Failure); ok {
+				out <- fl
+				continue
+			}
 			d := x.Value().(Dup) // Type assert
+			counts[d.Hash]++
+
+			// Track scanned paths the group spans
+			if f.CrossRootsOnly {
+				if roots[d.Hash] == nil {
+					roots[d.Hash] = make(map[string]struct{})
+				}
+				roots[d.Hash][d.Root] = struct{}{}
+			}
 
 			// Aggregate
 			if v, ok := byHash[d.Hash]; ok {
+				// Keep only a sample of pathologically large groups
+				if f.MaxGroupMembers > 0 && len(v) >= f.MaxGroupMembers {
+					continue
+				}
 				// Found node with the same content
 				byHash[d.Hash] = append(v, d)
 			} else {
@@ -175,21 +945,140 @@ func (f *Finder) reduceDups() mapreduce.ReduceFn {
 			}
 		}
 
+		// Optionally make sure the content is the same
+		if f.CompareBytes {
+			byHash, counts = f.compareGroups(byHash, counts)
+		}
+
 		// Reduce
-		for _, dups := range byHash {
-			count := len(dups)
+		for hash, dups := range byHash {
+			count := counts[hash]
+
+			// Skip groups within a single scanned path
+			if f.CrossRootsOnly && len(groupRoots(dups, count, roots)) < 2 {
+				atomic.AddUint64(&f.totalCopies, ^uint64(count-1)) // Not copies after all
+				continue
+			}
+
+			if count > len(dups) {
+				sink.Warning(fmt.Sprintf("group %s has %d members, reporting first %d", hash, count, len(dups)))
+			}
 			// Update free size stats
-			atomic.AddUint64(&f.totalWastedSpace, uint64(dups[0].Size*int64(count-1)))
+			wasted := uint64(f.sizeOf(dups[0].Node) * int64(count-1))
+			atomic.AddUint64(&f.totalWastedSpace, wasted)
+
+			// Optionally classify group content
+			if f.DetectContentTypes {
+				if mime, err := dups[0].DetectContentType(); err == nil {
+					f.wastedByType[mime] += wasted
+				} else {
+					sink.Warning(err.Error())
+				}
+			}
+
+			// Optionally estimate compressibility of the group content
+			var ratio float64
+			if f.CompressionSample > 0 {
+				if r, err := dups[0].CompressionRatio(f.CompressionSample); err == nil {
+					ratio = r
+				} else {
+					sink.Warning(err.Error())
+				}
+			}
 
 			for _, d := range dups {
 				// Update dups number stats
 				d.Count = count
+				d.Wasted = int64(wasted)
+				d.Ratio = ratio
 				out <- d
 			}
 		}
 	}
 }
 
+// Get scanned paths the group spans. Groups sampled by MaxGroupMembers rely on roots
+// tracked as members came, the others, including split groups, have all members known.
+func groupRoots(dups []Dup, count int, roots map[string]map[string]struct{}) map[string]struct{} {
+	if count > len(dups) {
+		return roots[dups[0].Hash]
+	}
+	spanned := make(map[string]struct{})
+	for _, d := range dups {
+		spanned[d.Root] = struct{}{}
+	}
+	return spanned
+}
+
+// Size used for wasted space accounting
+func (f *Finder) sizeOf(n *node.Node) int64 {
+	if f.UseDiskSize {
+		return n.DiskSize
+	}
+	return n.Size
+}
+
+// Check whether the file extension is in OnlyExtensions list
+func (f *Finder) allowedExtension(path string) bool {
+	if len(f.OnlyExtensions) == 0 {
+		return true
+	}
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	for _, e := range f.OnlyExtensions {
+		if strings.EqualFold(ext, strings.TrimPrefix(e, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check whether node is the first seen path of the file
+func (f *Finder) firstLink(n *node.Node) bool {
+	if n.Ino == 0 {
+		return true // Cannot tell
+	}
+
+	f.linksMu.Lock()
+	defer f.linksMu.Unlock()
+
+	if f.links == nil {
+		f.links = make(map[[2]uint64]struct{})
+	}
+	key := [2]uint64{n.Dev, n.Ino}
+	if _, seen := f.links[key]; seen {
+		return false
+	}
+	f.links[key] = struct{}{}
+	return true
+}
+
+// Check if the walked file is to be deduplicated. Deep files and files out of size range
+// are counted and listed, but out of dedup scope.
+func (f *Finder) inDedupScope(n *node.Node) bool {
+	if f.MaxHashDepth > 0 && depthOf(n.Root, n.Path) > f.MaxHashDepth {
+		return false
+	}
+	return f.sizeInRange(n.Size)
+}
+
+// Check whether files of the size are deduplicated
+func (f *Finder) sizeInRange(size int64) bool {
+	if size == 0 && !f.IncludeEmpty {
+		return false
+	}
+	return size >= f.MinSize && (f.MaxSize == 0 || size <= f.MaxSize)
+}
+
+// Get depth of path below root: root itself is at depth 0, its entries at depth 1, etc.
+func depthOf(root, path string) int {
+	rel := strings.TrimPrefix(path, filepath.Clean(root))
+	rel = strings.TrimLeft(rel, string(os.PathSeparator))
+	if rel == "" {
+		return 0
+	}
+	return strings.Count(rel, string(os.PathSeparator)) + 1
+}
+
 func isRegularFile(fi os.FileInfo) bool {
 	return fi.Mode()&os.ModeType == 0
 }
@@ -0,0 +1,1062 @@
+package finder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/caelifer/dups/cache"
+	"github.com/caelifer/dups/fstree"
+	"github.com/caelifer/dups/node"
+)
+
+// Create files with the given content under a new temporary directory
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// Collect duplicates found by f in paths, failing the test if the scan doesn't finish
+func collect(t *testing.T, f *Finder, paths ...string) []Dup {
+	t.Helper()
+	done := make(chan []Dup)
+	go func() {
+		var dups []Dup
+		for x := range f.AllDuplicateFiles(paths) {
+			if d, ok := x.(Dup); ok {
+				dups = append(dups, d)
+			}
+		}
+		done <- dups
+	}()
+
+	select {
+	case dups := <-done:
+		sort.Slice(dups, func(i, j int) bool { return dups[i].Path < dups[j].Path })
+		return dups
+	case <-time.After(30 * time.Second):
+		t.Fatal("scan didn't finish")
+		return nil
+	}
+}
+
+func TestMaxInFlightManyDirs(t *testing.T) {
+	// Many more directories than workers, all with the same content
+	files := make(map[string]string)
+	for i := 0; i < 50; i++ {
+		files[fmt.Sprintf("d%02d/a", i)] = "same content"
+		files[fmt.Sprintf("d%02d/b", i)] = "same content"
+	}
+	root := writeTree(t, files)
+
+	for _, inFlight := range []int{1, 3, 100} {
+		f := New(2)
+		f.Sink = NewTextSink(io.Discard)
+		f.MaxInFlight = inFlight
+		f.PrefixHashSize = 4 // Two hashing stages
+
+		if dups := collect(t, f, root); len(dups) != len(files) {
+			t.Errorf("max in flight %d: found %d dups, want %d", inFlight, len(dups), len(files))
+		}
+	}
+}
+
+func TestReserveHashBudgetConcurrent(t *testing.T) {
+	const (
+		budget  = 1000
+		size    = 10
+		workers = 50
+		tries   = 20
+	)
+
+	f := New(1)
+	f.MaxBytesHashed = budget
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+
+	var reserved uint64
+	wg := new(sync.WaitGroup)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < tries; j++ {
+				if f.reserveHashBudget(size) {
+					atomic.AddUint64(&reserved, size)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if reserved != budget {
+		t.Errorf("reserved %d bytes, want %d", reserved, budget)
+	}
+	if got := f.Summary().BytesHashed; got != reserved {
+		t.Errorf("accounted %d bytes, reserved %d", got, reserved)
+	}
+	if !f.BudgetExceeded() || f.ctx.Err() == nil {
+		t.Error("exhausted budget didn't cancel the scan")
+	}
+}
+
+func TestMaxBytesHashed(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("%02d/a", i)] = fmt.Sprintf("content %02d", i)
+		files[fmt.Sprintf("%02d/b", i)] = fmt.Sprintf("content %02d", i)
+	}
+	root := writeTree(t, files)
+
+	f := New(4)
+	f.Sink = NewTextSink(io.Discard)
+	f.MaxBytesHashed = 50
+	collect(t, f, root)
+
+	if got := f.Summary().BytesHashed; got > 50 {
+		t.Errorf("hashed %d bytes over budget of 50", got)
+	}
+	if !f.BudgetExceeded() {
+		t.Error("budget not reported as exceeded")
+	}
+}
+
+func TestWastedOnDisk(t *testing.T) {
+	root := writeTree(t, map[string]string{"a": "tiny", "b": "tiny", "c": "tiny"})
+
+	for _, onDisk := range []bool{false, true} {
+		f := New(2)
+		f.Sink = NewTextSink(io.Discard)
+		f.UseDiskSize = onDisk
+
+		dups := collect(t, f, root)
+		if len(dups) != 3 {
+			t.Fatalf("found %d dups, want 3", len(dups))
+		}
+		want := 2 * dups[0].Size
+		if onDisk {
+			want = 2 * dups[0].DiskSize
+		}
+		for _, d := range dups {
+			if d.Wasted != want {
+				t.Errorf("on disk %v: %s wastes %d bytes, want %d", onDisk, d.Path, d.Wasted, want)
+			}
+		}
+		if got := f.Summary().WastedSpace; got != uint64(want) {
+			t.Errorf("on disk %v: stats wasted %d bytes, want %d", onDisk, got, want)
+		}
+	}
+}
+
+// Cache pretending all files have the same hash
+type collidingCache struct{}
+
+func (collidingCache) Lookup(string, int64, time.Time) (string, bool) { return "collision", true }
+func (collidingCache) Store(string, int64, time.Time, string)         {}
+
+func TestCompareBytesSplit(t *testing.T) {
+	root := writeTree(t, map[string]string{"a1": "aaaa", "a2": "aaaa", "b1": "bbbb", "b2": "bbbb"})
+
+	f := New(2)
+	f.Sink = NewTextSink(io.Discard)
+	f.Cache = collidingCache{}
+	f.CompareBytes = true
+
+	dups := collect(t, f, root)
+	if len(dups) != 4 {
+		t.Fatalf("found %d dups, want 4", len(dups))
+	}
+	for _, d := range dups {
+		if d.Hash != "collision" {
+			t.Errorf("%s has hash %q, want the real one", d.Path, d.Hash)
+		}
+		if d.Count != 2 {
+			t.Errorf("%s is in group of %d, want 2", d.Path, d.Count)
+		}
+	}
+	if dups[0].Group() != dups[1].Group() || dups[2].Group() != dups[3].Group() {
+		t.Errorf("identical files in different groups: %q", []string{dups[0].Group(), dups[1].Group(), dups[2].Group(), dups[3].Group()})
+	}
+	if dups[0].Group() == dups[2].Group() || dups[0].Split == dups[2].Split {
+		t.Errorf("different files in group %q", dups[0].Group())
+	}
+}
+
+// Cache serving the same hash for all files
+type fixedCache string
+
+func (c fixedCache) Lookup(string, int64, time.Time) (string, bool) { return string(c), true }
+func (fixedCache) Store(string, int64, time.Time, string)           {}
+
+func TestRemoveDuplicatesRechecksContent(t *testing.T) {
+	root := writeTree(t, map[string]string{"a": "same", "b": "same", "cc": "diff"})
+
+	// Stale hash makes the different file look like a copy
+	n := &node.Node{Path: filepath.Join(root, "a"), Size: 4}
+	if err := n.CalculateHash(); err != nil {
+		t.Fatal(err)
+	}
+	f := New(2)
+	f.Sink = NewTextSink(io.Discard)
+	f.Cache = fixedCache(n.Hash)
+
+	removals := f.RemoveDuplicates([]string{root}, KeepShortestPath, false)
+	if len(removals) != 2 {
+		t.Fatalf("got %d removals, want 2", len(removals))
+	}
+	var removed int
+	for _, r := range removals {
+		_, err := os.Stat(r.Path)
+		if filepath.Base(r.Path) == "cc" {
+			if r.Err == nil || err != nil {
+				t.Errorf("different %s removed", r.Path)
+			}
+			continue
+		}
+		if r.Err != nil || !os.IsNotExist(err) {
+			t.Errorf("%s not removed: %v", r.Path, r.Err)
+		}
+		removed++
+	}
+	if removed != 1 {
+		t.Errorf("removed %d copies, want 1", removed)
+	}
+}
+
+func TestEmptyFilesListed(t *testing.T) {
+	root := writeTree(t, map[string]string{"src/empty": "", "src/other": "", "src/data": "data", "dst/data": "data"})
+
+	f := New(2)
+	f.Sink = NewTextSink(io.Discard)
+	if dups := collect(t, f, root); len(dups) != 2 {
+		t.Errorf("found %d dups, want only 2 non-empty ones", len(dups))
+	}
+
+	var listed int
+	for range f.ListFiles([]string{root}) {
+		listed++
+	}
+	if listed != 4 {
+		t.Errorf("listed %d files, want 4", listed)
+	}
+
+	var missing []string
+	for x := range f.MissingFiles([]string{filepath.Join(root, "src")}, []string{filepath.Join(root, "dst")}) {
+		missing = append(missing, filepath.Base(x.Value().(*node.Node).Path))
+	}
+	sort.Strings(missing)
+	if len(missing) != 2 || missing[0] != "empty" || missing[1] != "other" {
+		t.Errorf("missing %q, want empty files", missing)
+	}
+}
+
+func TestMaxGroupMembers(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		files[fmt.Sprintf("f%d", i)] = "same"
+	}
+	root := writeTree(t, files)
+
+	f := New(2)
+	f.Sink = NewTextSink(io.Discard)
+	f.MaxGroupMembers = 3
+
+	dups := collect(t, f, root)
+	if len(dups) != 3 {
+		t.Fatalf("reported %d members, want 3", len(dups))
+	}
+	for _, d := range dups {
+		if d.Count != 10 || d.Wasted != 9*4 {
+			t.Errorf("%s: count %d, wasted %d, want 10 and 36", d.Path, d.Count, d.Wasted)
+		}
+	}
+}
+
+func TestAllDuplicateFilesWithErrors(t *testing.T) {
+	root := writeTree(t, map[string]string{"a": "same", "b": "same"})
+	paths := []string{root}
+	for i := 0; i < maxHeldErrors+5; i++ {
+		paths = append(paths, filepath.Join(root, fmt.Sprintf("missing%d", i)))
+	}
+
+	f := New(2)
+	f.Sink = NewTextSink(io.Discard)
+
+	dups, errs := f.AllDuplicateFilesWithErrors(paths)
+	var found int
+	for range dups {
+		found++
+	}
+	var errors []error
+	for err := range errs {
+		errors = append(errors, err)
+	}
+
+	if found != 2 {
+		t.Errorf("found %d dups, want 2", found)
+	}
+	if len(errors) != maxHeldErrors+1 {
+		t.Fatalf("got %d errors, want %d", len(errors), maxHeldErrors+1)
+	}
+	if last := errors[len(errors)-1].Error(); !strings.HasPrefix(last, "5 more errors") {
+		t.Errorf("last error %q, want summary of 5 more", last)
+	}
+	if f.ReportFailures {
+		t.Error("ReportFailures left set")
+	}
+}
+
+func TestCompressionSample(t *testing.T) {
+	content := strings.Repeat("compressible ", 1000)
+	root := writeTree(t, map[string]string{"a": content, "b": content})
+
+	for _, sample := range []int64{0, 4096} {
+		f := New(2)
+		f.Sink = NewTextSink(io.Discard)
+		f.CompressionSample = sample
+
+		for _, d := range collect(t, f, root) {
+			if sample == 0 && d.Ratio != 0 {
+				t.Errorf("ratio %.2f without sampling", d.Ratio)
+			}
+			if sample > 0 && (d.Ratio <= 0 || d.Ratio > 0.1) {
+				t.Errorf("ratio %.2f of repetitive content", d.Ratio)
+			}
+		}
+	}
+}
+
+func TestOnlyExtensions(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a.jpg": "photo", "b.JPG": "photo", "c.png": "photo",
+		"d.txt": "photo", "e": "photo", "f.tar.jpg": "photo",
+	})
+
+	tests := []struct {
+		exts []string
+		want []string
+	}{
+		{nil, []string{"a.jpg", "b.JPG", "c.png", "d.txt", "e", "f.tar.jpg"}},
+		{[]string{"jpg"}, []string{"a.jpg", "b.JPG", "f.tar.jpg"}},
+		{[]string{".PNG", "jpg"}, []string{"a.jpg", "b.JPG", "c.png", "f.tar.jpg"}},
+		{[]string{"txt"}, nil}, // Single file isn't a duplicate
+		{[]string{"tar"}, nil},
+	}
+
+	for _, tt := range tests {
+		f := New(2)
+		f.Sink = NewTextSink(io.Discard)
+		f.OnlyExtensions = tt.exts
+
+		var got []string
+		for _, d := range collect(t, f, root) {
+			got = append(got, filepath.Base(d.Path))
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("extensions %q: found %q, want %q", tt.exts, got, tt.want)
+		}
+	}
+}
+
+func TestContentTypes(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a.txt": "some text", "b.txt": "some text", "c.txt": "some text",
+		"a.pdf": "%PDF-1.4\n", "b.pdf": "%PDF-1.4\n",
+		"single": "unique",
+	})
+
+	f := New(2)
+	f.Sink = NewTextSink(io.Discard)
+	f.DetectContentTypes = true
+	collect(t, f, root)
+
+	got := f.ContentTypes()
+	want := map[string]uint64{"text/plain": 2 * 9, "application/pdf": 9}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for mime, wasted := range want {
+		if got[mime] != wasted {
+			t.Errorf("%s: wasted %d, want %d", mime, got[mime], wasted)
+		}
+	}
+}
+
+func TestReduceShards(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 50; i++ {
+		files[fmt.Sprintf("f%02d", i)] = fmt.Sprintf("content %d", i%20)
+	}
+	root := writeTree(t, files)
+
+	var want []Dup
+	for _, shards := range []int{0, 1, 4} {
+		f := New(4)
+		f.Sink = NewTextSink(io.Discard)
+		f.ReduceShards = shards
+
+		dups := collect(t, f, root)
+		if shards == 0 {
+			want = dups
+			continue
+		}
+		if len(dups) != len(want) {
+			t.Fatalf("%d shards: found %d dups, want %d", shards, len(dups), len(want))
+		}
+		for i := range dups {
+			if dups[i].Path != want[i].Path || dups[i].Hash != want[i].Hash || dups[i].Count != want[i].Count {
+				t.Errorf("%d shards: got %v, want %v", shards, dups[i], want[i])
+			}
+		}
+	}
+	if len(want) != 50 {
+		t.Errorf("found %d dups, want 50", len(want))
+	}
+}
+
+func TestNonUTF8Paths(t *testing.T) {
+	root := writeTree(t, map[string]string{"bad\xff\xfe": "same", "good": "same"})
+	bad := filepath.Join(root, "bad\xff\xfe")
+
+	for _, nfc := range []bool{false, true} {
+		f := New(2)
+		f.Sink = NewTextSink(io.Discard)
+		f.NormalizeUnicode = nfc
+
+		dups := collect(t, f, root)
+		if len(dups) != 2 || dups[0].Path != bad {
+			t.Fatalf("nfc %v: found %v, want %q among 2 dups", nfc, dups, bad)
+		}
+		if s := dups[0].String(); !utf8.ValidString(s) || !strings.Contains(s, `bad\xff\xfe`) {
+			t.Errorf("nfc %v: rendered as %q", nfc, s)
+		}
+	}
+}
+
+func TestMaxHashDepth(t *testing.T) {
+	root := writeTree(t, map[string]string{"top": "same", "a/one": "same", "a/b/two": "same", "a/b/c/three": "same"})
+
+	for depth, want := range []int{4, 0, 2, 3, 4} {
+		f := New(2)
+		f.Sink = NewTextSink(io.Discard)
+		f.MaxHashDepth = depth
+
+		if dups := collect(t, f, root); len(dups) != want {
+			t.Errorf("depth %d: found %d dups, want %d", depth, len(dups), want)
+		}
+		if files := f.Summary().Files; files != 4 {
+			t.Errorf("depth %d: counted %d files, want all 4", depth, files)
+		}
+	}
+}
+
+func TestDepthOf(t *testing.T) {
+	sep := string(os.PathSeparator)
+	tests := []struct {
+		root, path string
+		want       int
+	}{
+		{"root", "root", 0},
+		{"root", "root" + sep + "f", 1},
+		{"root" + sep, "root" + sep + "d" + sep + "f", 2},
+		{".", "f", 1},
+		{".", ".hidden" + sep + "f", 2},
+		{sep, sep + "f", 1},
+	}
+
+	for _, tt := range tests {
+		if got := depthOf(tt.root, tt.path); got != tt.want {
+			t.Errorf("depthOf(%q, %q) = %d, want %d", tt.root, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestDigests(t *testing.T) {
+	root := writeTree(t, map[string]string{"a": "abc", "b": "abc"})
+
+	f := New(2)
+	f.Sink = NewTextSink(io.Discard)
+	f.Digests = []string{"md5"}
+	f.Cache = collidingCache{} // Cached hashes carry no digests
+
+	dups := collect(t, f, root)
+	if len(dups) != 2 {
+		t.Fatalf("found %d dups, want 2", len(dups))
+	}
+	for _, d := range dups {
+		if d.Digests["md5"] != "900150983cd24fb0d6963f7d28e17f72" {
+			t.Errorf("%s: digests %v", d.Path, d.Digests)
+		}
+	}
+}
+
+func TestReuseFinder(t *testing.T) {
+	root := writeTree(t, map[string]string{"a": "same", "b": "same", "c": "other", "d/e": "same"})
+
+	f := New(2)
+	f.Sink = NewTextSink(io.Discard)
+	f.IgnoreHardlinks = true
+
+	for i := 0; i < 2; i++ {
+		if dups := collect(t, f, root); len(dups) != 3 {
+			t.Errorf("scan %d: found %d dups, want 3", i, len(dups))
+		}
+		if s := f.Summary(); s.Files != 4 || s.Dirs != 2 || s.Copies != 3 {
+			t.Errorf("scan %d: counted %d files, %d dirs, %d copies, want 4, 2, 3", i, s.Files, s.Dirs, s.Copies)
+		}
+	}
+
+	var listed int
+	for range f.ListFiles([]string{root}) {
+		listed++
+	}
+	if listed != 4 {
+		t.Errorf("listed %d files after a scan, want 4", listed)
+	}
+	if s := f.Summary(); s.Files != 4 || s.Copies != 0 {
+		t.Errorf("listing counted %d files, %d copies, want 4 and 0", s.Files, s.Copies)
+	}
+}
+
+func TestSampledGroupsBySize(t *testing.T) {
+	long, short := strings.Repeat("x", 150), strings.Repeat("x", 140)
+	root := writeTree(t, map[string]string{"l1": long, "l2": long, "s1": short, "s2": short})
+
+	for _, verify := range []bool{false, true} {
+		f := New(2)
+		f.Sink = NewTextSink(io.Discard)
+		f.SampleStride, f.SampleSize = 64, 8
+		f.VerifySampled = verify
+
+		dups := collect(t, f, root)
+		if len(dups) != 4 {
+			t.Fatalf("verify %v: found %d dups, want 4", verify, len(dups))
+		}
+		for _, d := range dups {
+			if d.Count != 2 {
+				t.Errorf("verify %v: %s in group of %d, want 2", verify, d.Path, d.Count)
+			}
+		}
+		if dups[0].Group() == dups[2].Group() {
+			t.Errorf("verify %v: files of different sizes grouped together", verify)
+		}
+	}
+}
+
+func TestNormalizeUnicode(t *testing.T) {
+	// Same file seen via NFC and NFD spelled names, as on APFS
+	nfc, nfd := "caf\u00e9", "cafe\u0301"
+	root := writeTree(t, map[string]string{nfc: "content"})
+	if err := os.Link(filepath.Join(root, nfc), filepath.Join(root, nfd)); err != nil {
+		t.Skip("hard links not supported:", err)
+	}
+
+	for _, normalize := range []bool{false, true} {
+		f := New(2)
+		f.Sink = NewTextSink(io.Discard)
+		f.NormalizeUnicode = normalize
+
+		var listed []string
+		for x := range f.ListFiles([]string{root}) {
+			listed = append(listed, x.Value().(*node.Node).Path)
+		}
+		dups := collect(t, f, root)
+
+		want := 2 // Spurious self-duplicate
+		if normalize {
+			want = 0
+		}
+		if len(dups) != want {
+			t.Errorf("normalize %v: found %d dups, want %d", normalize, len(dups), want)
+		}
+		if normalize && len(listed) != 1 {
+			t.Errorf("normalize %v: listed %q, want a single file", normalize, listed)
+		}
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	root := writeTree(t, map[string]string{"a": "same", "b": "same", "d/c": "same", "d/e/f": "same"})
+
+	for _, tt := range []struct{ depth, dups, files int }{{-1, 4, 4}, {0, 0, 0}, {1, 2, 2}, {2, 3, 3}} {
+		f := New(2)
+		f.Sink = NewTextSink(io.Discard)
+		f.MaxDepth = tt.depth
+
+		if dups := collect(t, f, root); len(dups) != tt.dups {
+			t.Errorf("depth %d: found %d dups, want %d", tt.depth, len(dups), tt.dups)
+		}
+		if files := f.Summary().Files; files != uint64(tt.files) {
+			t.Errorf("depth %d: counted %d files, want %d", tt.depth, files, tt.files)
+		}
+	}
+}
+
+func TestIgnoreHardlinks(t *testing.T) {
+	root := writeTree(t, map[string]string{"a": "same", "c": "same", "x": "alone"})
+	for old, new := range map[string]string{"a": "b", "x": "y"} {
+		if err := os.Link(filepath.Join(root, old), filepath.Join(root, new)); err != nil {
+			t.Skip(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		ignore bool
+		want   []string
+	}{
+		{false, []string{"a", "b", "c", "x", "y"}},
+		{true, []string{"a", "c"}},
+	} {
+		f := New(2)
+		f.Sink = NewTextSink(io.Discard)
+		f.IgnoreHardlinks = tt.ignore
+
+		var got []string
+		for _, d := range collect(t, f, root) {
+			got = append(got, filepath.Base(d.Path))
+		}
+		// Either link may be seen first
+		if tt.ignore && len(got) == 2 && got[0] == "b" {
+			got[0] = "a"
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("ignore %v: found %q, want %q", tt.ignore, got, tt.want)
+		}
+	}
+}
+
+func TestAllDuplicateFilesContextCancel(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		files[fmt.Sprintf("d%d/f", i)] = "same"
+	}
+	root := writeTree(t, files)
+
+	f := New(2)
+	f.Sink = NewTextSink(io.Discard)
+	if err := f.Err(); err != nil {
+		t.Errorf("got %v before any scan", err)
+	}
+
+	// Cancelled upfront and midway
+	for _, after := range []int{0, 1} {
+		ctx, cancel := context.WithCancel(context.Background())
+		if after == 0 {
+			cancel()
+		}
+		results := f.AllDuplicateFilesContext(ctx, []string{root})
+
+		done := make(chan int)
+		go func() {
+			var n int
+			for range results {
+				if n++; n == after {
+					cancel()
+				}
+			}
+			done <- n
+		}()
+		select {
+		case n := <-done:
+			if after == 0 && n != 0 {
+				t.Errorf("cancelled upfront: got %d results", n)
+			}
+		case <-time.After(30 * time.Second):
+			t.Fatalf("cancelled after %d: result channel not closed", after)
+		}
+		if err := f.Err(); err != context.Canceled {
+			t.Errorf("cancelled after %d: got %v, want %v", after, err, context.Canceled)
+		}
+		cancel()
+	}
+
+	// Complete scan clears it
+	collect(t, f, root)
+	if err := f.Err(); err != nil {
+		t.Errorf("got %v after a complete scan", err)
+	}
+}
+
+func TestForEachDuplicateStops(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("d%d/f", i)] = "same"
+	}
+	root := writeTree(t, files)
+
+	f := New(2)
+	f.Sink = NewTextSink(io.Discard)
+
+	// All duplicates without an error
+	var calls int
+	err := f.ForEachDuplicate([]string{root}, func(Dup) error {
+		calls++
+		return nil
+	})
+	if err != nil || calls != len(files) {
+		t.Errorf("got %d calls and %v, want %d and nil", calls, err, len(files))
+	}
+
+	// No calls after an error
+	stop := fmt.Errorf("stop")
+	calls = 0
+	err = f.ForEachDuplicate([]string{root}, func(Dup) error {
+		if calls++; calls == 3 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("got %v, want %v", err, stop)
+	}
+	if calls != 3 {
+		t.Errorf("got %d calls, want 3", calls)
+	}
+}
+
+func TestCrossRootsOnly(t *testing.T) {
+	one := writeTree(t, map[string]string{"a": "shared", "b": "shared", "c": "only one", "d": "only one", "e": "many"})
+	two := writeTree(t, map[string]string{"a": "shared", "c": "only two", "d": "only two", "e": "many"})
+
+	for _, tt := range []struct {
+		crossOnly bool
+		limit     int
+		want      []string
+	}{
+		{false, 0, []string{"1/a", "1/b", "1/c", "1/d", "1/e", "2/a", "2/c", "2/d", "2/e"}},
+		{true, 0, []string{"1/a", "1/b", "1/e", "2/a", "2/e"}},
+		{true, 1, []string{"many", "shared"}}, // Roots of limited groups are known
+	} {
+		f := New(2)
+		f.Sink = NewTextSink(io.Discard)
+		f.CrossRootsOnly = tt.crossOnly
+		f.MaxGroupMembers = tt.limit
+
+		var got []string
+		for _, d := range collect(t, f, one, two) {
+			name := map[string]string{one: "1/", two: "2/"}[d.Root] + filepath.Base(d.Path)
+			if tt.limit > 0 {
+				// Any member may be reported, tell groups by content
+				data, err := os.ReadFile(d.Path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				name = string(data)
+			}
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("cross roots only %v, limit %d: found %q, want %q", tt.crossOnly, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	root := writeTree(t, map[string]string{"a": "same", "b": "same", "c": "different"})
+	b := filepath.Join(root, "b")
+
+	f := New(2)
+	f.Sink = NewTextSink(io.Discard)
+	c := cache.New("sha1")
+	f.Cache = c
+
+	if dups := collect(t, f, root); len(dups) != 2 {
+		t.Fatalf("found %d dups, want 2", len(dups))
+	}
+	if c.Len() != 2 {
+		t.Fatalf("cached %d hashes, want 2", c.Len())
+	}
+
+	// Same size, later modification time
+	if err := os.WriteFile(b, []byte("diff"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(b, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if dups := collect(t, f, root); len(dups) != 0 {
+		t.Errorf("found %d dups after modification, want 0", len(dups))
+	}
+
+	// Other size, same modification time
+	info, err := os.Stat(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte("different"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(b, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if dups := collect(t, f, root); len(dups) != 2 || dups[0].Path != b {
+		t.Errorf("found %v after resizing, want b and c", dups)
+	}
+}
+
+func TestSkippedSummary(t *testing.T) {
+	root := writeTree(t, map[string]string{"a": "same", "b": "same"})
+	// Links which can't be followed
+	for name, target := range map[string]string{"dangling": "nowhere", "loop": "loop"} {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skip("symbolic links not supported:", err)
+		}
+	}
+
+	for _, verbose := range []bool{false, true} {
+		rec := new(progressRecorder)
+		f := New(2)
+		f.Sink = rec
+		f.Symlinks = fstree.Follow
+		f.Verbose = verbose
+
+		if dups := collect(t, f, root); len(dups) != 2 {
+			t.Errorf("verbose %v: found %d dups, want 2", verbose, len(dups))
+		}
+		if s := f.Summary(); s.Skipped != 2 || !strings.Contains(s.String(), "skipped 2 unreadable paths") {
+			t.Errorf("verbose %v: got summary %q, want 2 skipped", verbose, s)
+		}
+
+		rec.mu.Lock()
+		warnings, debugs := strings.Join(rec.warnings, "\n"), strings.Join(rec.debugs, "\n")
+		rec.mu.Unlock()
+
+		// Reasons are warnings with -v, debug messages otherwise
+		details := debugs
+		if verbose {
+			details = warnings
+		}
+		for _, name := range []string{"dangling", "loop"} {
+			if !strings.Contains(details, filepath.Join(root, name)+":") {
+				t.Errorf("verbose %v: reasons %q lack %s", verbose, details, name)
+			}
+		}
+		if verbose && (len(rec.warnings) != 2 || strings.Contains(warnings, "skipped")) {
+			t.Errorf("verbose: got warnings %q, want one per path", rec.warnings)
+		}
+		if !verbose && (len(rec.warnings) != 1 || rec.warnings[0] != "skipped 2 unreadable paths") {
+			t.Errorf("got warnings %q, want a single summary", rec.warnings)
+		}
+	}
+}
+
+func TestLinkDuplicates(t *testing.T) {
+	root := writeTree(t, map[string]string{"a": "same", "bb": "same", "c": "diff"})
+	a, bb := filepath.Join(root, "a"), filepath.Join(root, "bb")
+
+	f := New(2)
+	f.Sink = NewTextSink(io.Discard)
+
+	// Dry run changes nothing
+	removals := f.LinkDuplicates([]string{root}, KeepShortestPath, true)
+	if len(removals) != 1 || removals[0].Path != bb || removals[0].Kept != a || removals[0].Err != nil {
+		t.Fatalf("dry run gave %+v, want bb linked to a", removals)
+	}
+	if sameInode(t, a, bb) {
+		t.Fatal("dry run linked the files")
+	}
+
+	removals = f.LinkDuplicates([]string{root}, KeepShortestPath, false)
+	if len(removals) != 1 || removals[0].Err != nil {
+		t.Fatalf("got %+v, want bb linked to a", removals)
+	}
+	if !sameInode(t, a, bb) {
+		t.Error("files not linked")
+	}
+
+	// Nothing to reclaim once linked
+	removals = f.LinkDuplicates([]string{root}, KeepShortestPath, false)
+	if len(removals) != 1 || removals[0].Err == nil || !strings.Contains(removals[0].Err.Error(), "already linked") {
+		t.Errorf("got %+v, want linked copy skipped", removals)
+	}
+}
+
+func TestLinkDuplicatesRechecksContent(t *testing.T) {
+	root := writeTree(t, map[string]string{"a": "same", "b": "same", "cc": "diff"})
+
+	// Stale hash makes the different file look like a copy
+	n := &node.Node{Path: filepath.Join(root, "a"), Size: 4}
+	if err := n.CalculateHash(); err != nil {
+		t.Fatal(err)
+	}
+	f := New(2)
+	f.Sink = NewTextSink(io.Discard)
+	f.Cache = fixedCache(n.Hash)
+
+	removals := f.LinkDuplicates([]string{root}, KeepShortestPath, false)
+	if len(removals) != 2 {
+		t.Fatalf("got %d removals, want 2", len(removals))
+	}
+	for _, r := range removals {
+		linked := sameInode(t, r.Kept, r.Path)
+		if filepath.Base(r.Path) == "cc" {
+			if r.Err == nil || linked {
+				t.Errorf("different %s linked", r.Path)
+			}
+			continue
+		}
+		if r.Err != nil || !linked {
+			t.Errorf("%s not linked: %v", r.Path, r.Err)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(root, "cc"))
+	if err != nil || string(data) != "diff" {
+		t.Errorf("cc has %q, %v, want its content", data, err)
+	}
+}
+
+// Check whether paths are links to the same file
+func sameInode(t *testing.T, a, b string) bool {
+	t.Helper()
+	ai, err := os.Stat(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return os.SameFile(ai, bi)
+}
+
+// Peak number of goroutines while fn runs, sampled
+func peakGoroutines(fn func()) int {
+	peak := runtime.NumGoroutine()
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		for {
+			if n := runtime.NumGoroutine(); n > peak {
+				peak = n
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(100 * time.Microsecond):
+			}
+		}
+	}()
+	fn()
+	close(done)
+	<-sampled
+	return peak
+}
+
+func BenchmarkMaxInFlight(b *testing.B) {
+	// Files of the same size all go to hashing
+	root := b.TempDir()
+	for i := 0; i < 5000; i++ {
+		path := filepath.Join(root, fmt.Sprintf("d%02d", i%50), fmt.Sprintf("f%04d", i))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			b.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("%08d", i)), 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	for _, inFlight := range []int{0, 16, 256} {
+		b.Run(fmt.Sprintf("in-flight-%d", inFlight), func(b *testing.B) {
+			b.ReportAllocs()
+			var peak int
+			for i := 0; i < b.N; i++ {
+				f := New(4)
+				f.Sink = NewTextSink(io.Discard)
+				f.MaxInFlight = inFlight
+				if n := peakGoroutines(func() {
+					for range f.AllDuplicateFiles([]string{root}) {
+					}
+				}); n > peak {
+					peak = n
+				}
+			}
+			b.ReportMetric(float64(peak), "peak-goroutines")
+		})
+	}
+}
+
+func BenchmarkWalkOverlap(b *testing.B) {
+	// Mixed workload: mostly unique sizes, some larger copies
+	root := b.TempDir()
+	for i := 0; i < 2000; i++ {
+		content := strings.Repeat("u", i+1)
+		if i%10 == 0 {
+			content = strings.Repeat(fmt.Sprint(i%3), 64<<10)
+		}
+		path := filepath.Join(root, fmt.Sprintf("d%02d", i%40), fmt.Sprintf("f%04d", i))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			b.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	// Walk first, then hash files of the same size on as many workers
+	b.Run("walk-then-hash", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			f := New(4)
+			f.Sink = NewTextSink(io.Discard)
+			bySize := make(map[int64][]*node.Node)
+			for x := range f.ListFiles([]string{root}) {
+				n := x.Value().(*node.Node)
+				bySize[n.Size] = append(bySize[n.Size], n)
+			}
+
+			nodes := make(chan *node.Node)
+			var wg sync.WaitGroup
+			for w := 0; w < 4; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for n := range nodes {
+						if err := n.CalculateHash(); err != nil {
+							b.Error(err)
+						}
+					}
+				}()
+			}
+			for _, group := range bySize {
+				if len(group) > 1 {
+					for _, n := range group {
+						nodes <- n
+					}
+				}
+			}
+			close(nodes)
+			wg.Wait()
+		}
+	})
+
+	// Pipeline hashing while the walk goes on
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			f := New(4)
+			f.Sink = NewTextSink(io.Discard)
+			for range f.AllDuplicateFiles([]string{root}) {
+			}
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package finder
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/caelifer/dups/node"
+)
+
+// KeepPolicy reports whether a is preferred over b as the canonical copy to keep.
+// It must return false for both orders when a and b are equally good.
+type KeepPolicy func(a, b *node.Node) bool
+
+// KeepFirst has no preference, so the lexicographically first path is kept.
+func KeepFirst(a, b *node.Node) bool {
+	return false
+}
+
+// KeepOldest prefers the least recently modified file.
+func KeepOldest(a, b *node.Node) bool {
+	return a.ModTime.Before(b.ModTime)
+}
+
+// KeepNewest prefers the most recently modified file.
+func KeepNewest(a, b *node.Node) bool {
+	return a.ModTime.After(b.ModTime)
+}
+
+// KeepShortestPath prefers the file with the shortest path.
+func KeepShortestPath(a, b *node.Node) bool {
+	return len(a.Path) < len(b.Path)
+}
+
+// KeepInRoot prefers files located under the root directory.
+func KeepInRoot(root string) KeepPolicy {
+	root = filepath.Clean(root)
+	under := func(path string) bool {
+		return path == root || strings.HasPrefix(path, root+string(os.PathSeparator))
+	}
+	return func(a, b *node.Node) bool {
+		return under(a.Path) && !under(b.Path)
+	}
+}
+
+// Chain combines policies so that each next policy only breaks ties of the previous ones.
+func Chain(policies ...KeepPolicy) KeepPolicy {
+	return func(a, b *node.Node) bool {
+		for _, keep := range policies {
+			switch {
+			case keep(a, b):
+				return true
+			case keep(b, a):
+				return false
+			}
+		}
+		return false
+	}
+}
+
+// SortByPolicy orders duplicates of a single group by preference, so that the first one
+// is the canonical copy to keep. Ties are broken by path, which makes the selection
+// deterministic. This is the only place where the canonical copy is chosen.
+func SortByPolicy(dups []Dup, keep KeepPolicy) {
+	less := Chain(keep, func(a, b *node.Node) bool { return a.Path < b.Path })
+	sort.Slice(dups, func(i, j int) bool {
+		return less(dups[i].Node, dups[j].Node)
+	})
+}
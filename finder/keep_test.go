@@ -0,0 +1,49 @@
+package finder
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caelifer/dups/node"
+)
+
+func TestSortByPolicy(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	group := func() []Dup {
+		return []Dup{
+			{Node: &node.Node{Path: filepath.FromSlash("b/long/name"), ModTime: t0}},
+			{Node: &node.Node{Path: filepath.FromSlash("keep/x"), ModTime: t0.Add(time.Hour)}},
+			{Node: &node.Node{Path: filepath.FromSlash("a/long/name"), ModTime: t0}},
+			{Node: &node.Node{Path: filepath.FromSlash("keeper/y"), ModTime: t0.Add(-time.Hour)}},
+		}
+	}
+
+	tests := []struct {
+		name string
+		keep KeepPolicy
+		want string
+	}{
+		{"first", KeepFirst, "a/long/name b/long/name keep/x keeper/y"},
+		{"oldest", KeepOldest, "keeper/y a/long/name b/long/name keep/x"},
+		{"newest", KeepNewest, "keep/x a/long/name b/long/name keeper/y"},
+		{"shortest", KeepShortestPath, "keep/x keeper/y a/long/name b/long/name"},
+		{"dir", KeepInRoot("keep/"), "keep/x a/long/name b/long/name keeper/y"}, // Not a prefix match
+		{"chain", Chain(KeepShortestPath, KeepOldest), "keep/x keeper/y a/long/name b/long/name"},
+		{"chain ties", Chain(KeepOldest, KeepShortestPath), "keeper/y a/long/name b/long/name keep/x"},
+		{"empty chain", Chain(), "a/long/name b/long/name keep/x keeper/y"},
+	}
+
+	for _, tt := range tests {
+		dups := group()
+		SortByPolicy(dups, tt.keep)
+		var got []string
+		for _, d := range dups {
+			got = append(got, filepath.ToSlash(d.Path))
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package finder
+
+import (
+	"context"
+
+	"github.com/caelifer/dups/mapreduce"
+	"github.com/caelifer/dups/node"
+)
+
+// MissingFiles finds files under source paths whose content is found nowhere under target
+// paths, e.g. to check that a backup is complete. A source file matching any number of
+// target files is present. It returns a channel of *node.Node values of missing files;
+// those without target files of the same size are reported without being hashed. Source
+// and target paths must not overlap.
+func (f *Finder) MissingFiles(source, target []string) <-chan mapreduce.Value {
+	f.startScan(context.Background())
+
+	// Tell source files by the scanned path they were found under
+	sources := make(map[string]bool)
+	for _, p := range source {
+		sources[p] = true
+	}
+	isSource := func(n *node.Node) bool {
+		return sources[n.Root]
+	}
+
+	paths := append(append([]string(nil), source...), target...)
+	unmatched := make(chan mapreduce.Value) // Missing by size
+
+	hashed := mapreduce.Pipeline(
+		[]mapreduce.MapReducePair{
+			{
+				Map:    f.makeNodeMap(paths),
+				Reduce: mapreduce.Sharded(f.ReduceShards, mapreduce.FilterOutDuplicates),
+			}, {
+				Map:    f.makeFileSizeMap(nil),
+				Reduce: f.reduceMissing(isSource, unmatched),
+			}, {
+				Map:    f.makeFileHashMap(fullHash),
+				Reduce: f.reduceMissing(isSource, nil),
+			},
+		}...,
+	)
+	return mapreduce.Merge(hashed, unmatched)
+}
+
+// Reduce source and target files grouped by key. Source files of groups without target
+// files are missing, they are sent to the missing channel, if any, and to the output
+// otherwise. If missing is given, groups with both source and target files are sent out
+// as a whole to be compared further, otherwise they are dropped as present.
+func (f *Finder) reduceMissing(isSource func(n *node.Node) bool, missing chan<- mapreduce.Value) mapreduce.ReduceFn {
+	return func(out chan<- mapreduce.Value, in <-chan mapreduce.KeyValue) {
+		if missing != nil {
+			defer close(missing)
+		}
+
+		groups := make(map[mapreduce.KeyType][]*node.Node)
+		inSource := make(map[mapreduce.KeyType]bool)
+		inTarget := make(map[mapreduce.KeyType]bool)
+
+		for x := range in {
+			// Pass failures through
+			if fl, ok := x.(*mapreduce.Failure); ok {
+				out <- fl
+				continue
+			}
+			n := x.Value().(*node.Node) // Type assert
+			key := x.Key()
+
+			if isSource(n) {
+				inSource[key] = true
+			} else {
+				inTarget[key] = true
+			}
+			groups[key] = append(groups[key], n)
+		}
+
+		for key, nodes := range groups {
+			switch {
+			case !inSource[key]:
+				// Nothing to look for
+			case !inTarget[key]:
+				dst := out
+				if missing != nil {
+					dst = missing
+				}
+				for _, n := range nodes {
+					dst <- n
+				}
+			case missing != nil:
+				// Compare content
+				for _, n := range nodes {
+					out <- n
+				}
+			}
+		}
+	}
+}
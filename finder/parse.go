@@ -0,0 +1,86 @@
+package finder
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/caelifer/dups/node"
+)
+
+// ParseDup parses a single line produced by Dup.String. Optional columns following the
+// path (compression ratio and wasted share) are restored too. The path may contain any
+// characters, including colons and escaped quotes.
+func ParseDup(line string) (Dup, error) {
+	var d Dup
+
+	// Hash, count and size never contain colons, the rest starts with a quoted path
+	fields := strings.SplitN(line, ":", 4)
+	if len(fields) != 4 {
+		return d, fmt.Errorf("malformed line %q", line)
+	}
+
+	count, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return d, fmt.Errorf("bad count in %q: %v", line, err)
+	}
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return d, fmt.Errorf("bad size in %q: %v", line, err)
+	}
+
+	// Optional columns never contain quotes
+	end := strings.LastIndex(fields[3], `"`) + 1
+	path, err := strconv.Unquote(fields[3][:end])
+	if err != nil {
+		return d, fmt.Errorf("bad path in %q: %v", line, err)
+	}
+
+	for _, opt := range strings.Split(fields[3][end:], ":")[1:] {
+		var err error
+		if strings.HasSuffix(opt, "%") {
+			d.WastedShare, err = strconv.ParseFloat(strings.TrimSuffix(opt, "%"), 64)
+		} else {
+			d.Ratio, err = strconv.ParseFloat(opt, 64)
+		}
+		if err != nil {
+			return d, fmt.Errorf("bad column in %q: %v", line, err)
+		}
+	}
+
+	d.Node = &node.Node{Hash: fields[0], Size: size, Path: path}
+	d.Count = count
+	return d, nil
+}
+
+// ParseDups parses lines produced by Dup.String read from r, skipping blank lines which
+// separate groups. Parsing stops at the first error, which is sent to the error channel.
+// Both channels are closed once r is exhausted.
+func ParseDups(r io.Reader) (<-chan Dup, <-chan error) {
+	out := make(chan Dup)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(errc)
+		defer close(out)
+
+		scanner := bufio.NewScanner(r)
+		for line := 1; scanner.Scan(); line++ {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			d, err := ParseDup(scanner.Text())
+			if err != nil {
+				errc <- fmt.Errorf("line %d: %v", line, err)
+				return
+			}
+			out <- d
+		}
+		if err := scanner.Err(); err != nil {
+			errc <- err
+		}
+	}()
+	return out, errc
+}
@@ -0,0 +1,43 @@
+package finder
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/caelifer/dups/node"
+)
+
+func TestParseDupRoundTrip(t *testing.T) {
+	for _, d := range []Dup{
+		{Node: &node.Node{Hash: "h", Size: 1, Path: "plain"}, Count: 2},
+		{Node: &node.Node{Hash: "h", Size: 1, Path: `a:"b":c`}, Count: 2, Ratio: 1.5},
+		{Node: &node.Node{Hash: "h", Size: 1, Path: "tab\tnew\nline"}, Count: 3, WastedShare: 12.5},
+		{Node: &node.Node{Hash: "h", Size: 1, Path: "bad\xffutf8"}, Count: 2, Ratio: 2, WastedShare: 50},
+	} {
+		got, err := ParseDup(d.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != d.String() || got.Path != d.Path {
+			t.Errorf("parsed %s, want %s", got, d)
+		}
+	}
+}
+
+func TestParseDups(t *testing.T) {
+	in := "h:2:1:\"a\"\nh:2:1:\"b\"\n\n  \ng:2:1:\"c\"\nbroken\ng:2:1:\"d\"\n"
+
+	var paths []string
+	dups, errc := ParseDups(strings.NewReader(in))
+	for d := range dups {
+		paths = append(paths, d.Path)
+	}
+	err := <-errc
+
+	if strings.Join(paths, ",") != "a,b,c" {
+		t.Errorf("parsed %q, want a, b and c", paths)
+	}
+	if err == nil || !strings.HasPrefix(err.Error(), "line 6:") {
+		t.Errorf("got error %v, want one on line 6", err)
+	}
+}
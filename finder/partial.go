@@ -0,0 +1,162 @@
+package finder
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/caelifer/dups/node"
+)
+
+// PartialDupHead is the number of leading bytes by which candidate partial duplicates
+// are grouped. Smaller files are never reported as partial duplicates.
+const PartialDupHead = 4096
+
+// PartialDup describes a file whose whole content is the beginning of a larger file,
+// e.g. an interrupted download.
+type PartialDup struct {
+	Prefix *node.Node // Truncated copy
+	Whole  *node.Node // Larger file starting with the content of Prefix
+}
+
+// Pretty printer for the report
+func (d PartialDup) String() string {
+	return fmt.Sprintf("%d:%q:%d:%q", d.Prefix.Size, d.Prefix.Path, d.Whole.Size, d.Whole.Path)
+}
+
+// PartialDuplicates finds files whose content equals the beginning of a larger file.
+// To keep the work bounded, only files of at least PartialDupHead bytes are considered,
+// and they are only compared with files starting with the same PartialDupHead bytes.
+// Each candidate is read once, on the scheduler, and the reads are charged against
+// MaxBytesHashed.
+func (f *Finder) PartialDuplicates(paths []string) []PartialDup {
+	sink := f.sink()
+
+	var candidates []*node.Node
+	for x := range f.ListFiles(paths) {
+		n := x.Value().(*node.Node) // Assert type
+		if n.Size >= PartialDupHead {
+			candidates = append(candidates, n)
+		}
+	}
+
+	// Group candidates by their leading bytes
+	var mu sync.Mutex
+	byHead := make(map[string][]*node.Node)
+	f.hashEach(candidates, func(*node.Node) int64 { return PartialDupHead }, func(n *node.Node) {
+		head, err := n.PrefixHash(PartialDupHead)
+		if err != nil {
+			sink.Warning(err.Error())
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		byHead[head] = append(byHead[head], n)
+	})
+
+	// Each file is hashed at the sizes of shorter files of its group, which it might start
+	// with, and at its own size if there are longer files
+	var todo []*node.Node
+	sizesOf := make(map[*node.Node][]int64)
+	for _, nodes := range byHead {
+		sizes := distinctSizes(nodes)
+		if len(sizes) < 2 {
+			continue // Exact duplicates are reported elsewhere
+		}
+		for _, n := range nodes {
+			for _, size := range sizes[:len(sizes)-1] {
+				if size <= n.Size {
+					sizesOf[n] = append(sizesOf[n], size)
+				}
+			}
+			todo = append(todo, n)
+		}
+	}
+
+	hashes := make(map[*node.Node][]string)
+	f.hashEach(todo, func(n *node.Node) int64 {
+		sizes := sizesOf[n]
+		return sizes[len(sizes)-1]
+	}, func(n *node.Node) {
+		h, err := n.PrefixHashes(sizesOf[n])
+		if err != nil {
+			sink.Warning(err.Error())
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		hashes[n] = h
+	})
+
+	// Index whole content of the shorter files
+	type prefixKey struct {
+		size int64
+		hash string
+	}
+	prefixes := make(map[prefixKey][]*node.Node)
+	for n, h := range hashes {
+		sizes := sizesOf[n]
+		if last := len(sizes) - 1; sizes[last] == n.Size {
+			n.Hash = h[last]
+			key := prefixKey{n.Size, n.Hash}
+			prefixes[key] = append(prefixes[key], n)
+		}
+	}
+
+	var res []PartialDup
+	for whole, h := range hashes {
+		for i, size := range sizesOf[whole] {
+			if size == whole.Size {
+				continue // Same size files aren't partial copies
+			}
+			for _, prefix := range prefixes[prefixKey{size, h[i]}] {
+				res = append(res, PartialDup{Prefix: prefix, Whole: whole})
+			}
+		}
+	}
+
+	// Stable output
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Prefix.Path != res[j].Prefix.Path {
+			return res[i].Prefix.Path < res[j].Prefix.Path
+		}
+		return res[i].Whole.Path < res[j].Whole.Path
+	})
+	return res
+}
+
+// Run fn for each node on the scheduler, charging the hashing budget with the number of
+// bytes it reads. Once the scan is cancelled or the budget is exhausted, the remaining
+// nodes are skipped.
+func (f *Finder) hashEach(nodes []*node.Node, bytes func(n *node.Node) int64, fn func(n *node.Node)) {
+	wg := new(sync.WaitGroup) // Heap
+	for _, n := range nodes {
+		if f.ctx.Err() != nil || !f.reserveHashBudget(bytes(n)) {
+			break
+		}
+
+		wg.Add(1)
+		go func(n *node.Node) {
+			f.scheduler.Schedule(func() {
+				defer wg.Done() // Signal done
+				f.current.Store(n.Path)
+				fn(n)
+			})
+		}(n)
+	}
+	wg.Wait()
+}
+
+// Get sizes of the nodes in ascending order, without repetitions
+func distinctSizes(nodes []*node.Node) []int64 {
+	seen := make(map[int64]bool)
+	var sizes []int64
+	for _, n := range nodes {
+		if !seen[n.Size] {
+			seen[n.Size] = true
+			sizes = append(sizes, n.Size)
+		}
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	return sizes
+}
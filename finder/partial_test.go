@@ -0,0 +1,50 @@
+package finder
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPartialDuplicates(t *testing.T) {
+	data := strings.Repeat("0123456789", 1000)
+	other := data[:PartialDupHead] + strings.Repeat("x", 2000)
+	root := writeTree(t, map[string]string{
+		"whole":  data,
+		"half":   data[:5000],
+		"third":  data[:PartialDupHead+1],
+		"copy":   data[:5000],
+		"other":  other,
+		"small":  data[:100],
+		"longer": other + "y",
+	})
+
+	f := New(2)
+	f.Sink = NewTextSink(io.Discard)
+
+	var got []string
+	for _, d := range f.PartialDuplicates([]string{root}) {
+		got = append(got, filepath.Base(d.Prefix.Path)+"<"+filepath.Base(d.Whole.Path))
+	}
+	want := []string{"copy<whole", "half<whole", "other<longer", "third<copy", "third<half", "third<whole"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPartialDuplicatesBudget(t *testing.T) {
+	data := strings.Repeat("0123456789", 1000)
+	root := writeTree(t, map[string]string{"whole": data, "half": data[:5000]})
+
+	f := New(2)
+	f.Sink = NewTextSink(io.Discard)
+	f.MaxBytesHashed = 3 * PartialDupHead
+
+	if res := f.PartialDuplicates([]string{root}); len(res) != 0 {
+		t.Errorf("found %d partial dups over budget", len(res))
+	}
+	if !f.BudgetExceeded() {
+		t.Error("budget not reported as exceeded")
+	}
+}
@@ -0,0 +1,229 @@
+package finder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/caelifer/dups/mapreduce"
+)
+
+// Progress is a snapshot of a running scan.
+type Progress struct {
+	Files       uint64 `json:"files"`
+	Dirs        uint64 `json:"dirs"`
+	BytesHashed uint64 `json:"bytes_hashed"`
+	Current     string `json:"current"`
+}
+
+// Summary holds final scan statistics in machine-readable form, see Finder.Summary.
+type Summary struct {
+	Files          uint64        `json:"files"`
+	Dirs           uint64        `json:"dirs"`
+	Copies         uint64        `json:"copies"`
+	WastedSpace    uint64        `json:"wasted_space"`
+	BytesHashed    uint64        `json:"bytes_hashed"`
+	Skipped        uint64        `json:"skipped"` // Unreadable paths
+	Elapsed        time.Duration `json:"elapsed_ns"`
+	MaxBytesHashed int64         `json:"max_bytes_hashed,omitempty"`
+	BudgetExceeded bool          `json:"budget_exceeded"`
+
+	// Per-stage timing, see Finder.TimeStages
+	Stages []mapreduce.StageStats `json:"stages,omitempty"`
+}
+
+// RunStats holds scan totals, see Finder.StatsStruct.
+type RunStats struct {
+	TotalDirs        uint64        `json:"total_dirs"`
+	TotalFiles       uint64        `json:"total_files"`
+	TotalCopies      uint64        `json:"total_copies"`
+	TotalWastedSpace uint64        `json:"total_wasted_space"`
+	TotalTime        time.Duration `json:"total_time_ns"`
+}
+
+// JSON marshals s to a JSON object.
+func (s RunStats) JSON() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+// ProgressSink receives all diagnostic output of a Finder: periodic progress updates,
+// per-file debug messages, informational messages, warnings about conditions worth
+// attention and final stats.
+// Implementations must be safe for concurrent use.
+type ProgressSink interface {
+	Progress(p Progress)
+	Debug(msg string)
+	Info(msg string)
+	Warning(msg string)
+	Stats(s Summary)
+}
+
+// Level is the severity of diagnostic output, see FilterSink.
+type Level int
+
+const (
+	LevelDebug Level = iota // Everything, including per-file messages
+	LevelInfo               // Progress updates, messages and stats
+	LevelWarn               // Warnings
+	LevelError              // Nothing but errors, which are returned to the caller
+)
+
+// ParseLevel converts level name (debug, info, warn or error) to Level.
+func ParseLevel(s string) (Level, error) {
+	switch s {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, fmt.Errorf("unknown level %q", s)
+	}
+}
+
+// FilterSink wraps sink so that only events of at least the given level are passed to
+// it. Debug messages are of the debug level, progress updates, messages and stats of the
+// info level, warnings of the warn level.
+func FilterSink(sink ProgressSink, level Level) ProgressSink {
+	return filterSink{sink, level}
+}
+
+type filterSink struct {
+	sink  ProgressSink
+	level Level
+}
+
+func (s filterSink) Progress(p Progress) {
+	if s.level <= LevelInfo {
+		s.sink.Progress(p)
+	}
+}
+
+func (s filterSink) Debug(msg string) {
+	if s.level <= LevelDebug {
+		s.sink.Debug(msg)
+	}
+}
+
+func (s filterSink) Info(msg string) {
+	if s.level <= LevelInfo {
+		s.sink.Info(msg)
+	}
+}
+
+func (s filterSink) Warning(msg string) {
+	if s.level <= LevelWarn {
+		s.sink.Warning(msg)
+	}
+}
+
+func (s filterSink) Stats(sum Summary) {
+	if s.level <= LevelInfo {
+		s.sink.Stats(sum)
+	}
+}
+
+// NewTextSink returns a sink writing human readable log lines to w.
+func NewTextSink(w io.Writer) ProgressSink {
+	return textSink{log.New(w, "", log.LstdFlags)}
+}
+
+type textSink struct {
+	*log.Logger
+}
+
+func (s textSink) Progress(p Progress) {
+	s.Printf("INFO heartbeat: examined %d files in %d directories, hashed %d bytes, processing %q",
+		p.Files, p.Dirs, p.BytesHashed, p.Current)
+}
+
+func (s textSink) Debug(msg string) {
+	s.Println("DEBUG", msg)
+}
+
+func (s textSink) Info(msg string) {
+	s.Println("INFO", msg)
+}
+
+func (s textSink) Warning(msg string) {
+	s.Println("WARN", msg)
+}
+
+func (s textSink) Stats(sum Summary) {
+	s.Printf("INFO stats: %s", sum)
+}
+
+// NewJSONSink returns a sink writing one JSON object per event to w. Each object has
+// "event" ("progress", "debug", "info", "warning" or "stats") and "time" fields along
+// with event data. Write errors are ignored, as there is nowhere else to report them.
+func NewJSONSink(w io.Writer) ProgressSink {
+	return &jsonSink{enc: json.NewEncoder(w)}
+}
+
+type jsonSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (s *jsonSink) Progress(p Progress) {
+	s.emit("progress", p)
+}
+
+func (s *jsonSink) Debug(msg string) {
+	s.emit("debug", struct {
+		Message string `json:"message"`
+	}{msg})
+}
+
+func (s *jsonSink) Info(msg string) {
+	s.emit("info", struct {
+		Message string `json:"message"`
+	}{msg})
+}
+
+func (s *jsonSink) Warning(msg string) {
+	s.emit("warning", struct {
+		Message string `json:"message"`
+	}{msg})
+}
+
+func (s *jsonSink) Stats(sum Summary) {
+	s.emit("stats", sum)
+}
+
+// Write a single event
+func (s *jsonSink) emit(event string, data interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_ = s.enc.Encode(struct {
+		Event string      `json:"event"`
+		Time  time.Time   `json:"time"`
+		Data  interface{} `json:"data"`
+	}{event, time.Now(), data})
+}
+
+func (s Summary) String() string {
+	str := fmt.Sprintf("examined %d files in %d directories [%s], found %d dups, total wasted space %.2fGiB",
+		s.Files, s.Dirs, s.Elapsed, s.Copies, float64(s.WastedSpace)/(1024*1024*1024))
+	if s.Skipped > 0 {
+		str += fmt.Sprintf(", skipped %d unreadable paths", s.Skipped)
+	}
+	if s.BudgetExceeded {
+		str += fmt.Sprintf(", hashing budget of %d bytes exhausted (partial results)", s.MaxBytesHashed)
+	}
+	for i, st := range s.Stages {
+		sep := ", "
+		if i == 0 {
+			sep = "; stages: "
+		}
+		str += fmt.Sprintf("%s%s %s (%d items, %.0f/s)", sep, st.Name, st.Active, st.Items, st.Throughput())
+	}
+	return str
+}
@@ -0,0 +1,264 @@
+package finder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Sink recording progress updates and warnings
+type progressRecorder struct {
+	mu       sync.Mutex
+	updates  []Progress
+	debugs   []string
+	warnings []string
+}
+
+func (r *progressRecorder) Progress(p Progress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.updates = append(r.updates, p)
+}
+
+func (r *progressRecorder) Debug(msg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.debugs = append(r.debugs, msg)
+}
+
+func (r *progressRecorder) Info(string) {}
+func (r *progressRecorder) Warning(msg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.warnings = append(r.warnings, msg)
+}
+
+func (r *progressRecorder) Stats(Summary) {}
+
+func (r *progressRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.updates)
+}
+
+// Wait for n warnings, returning all received ones
+func (r *progressRecorder) waitWarnings(n int) []string {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		r.mu.Lock()
+		warnings := append([]string(nil), r.warnings...)
+		r.mu.Unlock()
+		if len(warnings) >= n || time.Now().After(deadline) {
+			return warnings
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	root := writeTree(t, map[string]string{"a": "same", "b": "same", "dir/c": "other"})
+	rec := new(progressRecorder)
+	f := New(2)
+	f.Sink = rec
+	collect(t, f, root)
+
+	stop := f.StartHeartbeat(time.Millisecond)
+	deadline := time.Now().Add(10 * time.Second)
+	for rec.count() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+
+	n := rec.count()
+	if n < 2 {
+		t.Fatalf("got %d heartbeats, want at least 2", n)
+	}
+	if p := rec.updates[n-1]; p != f.Progress() {
+		t.Errorf("heartbeat %+v, want %+v", p, f.Progress())
+	} else if p.Files != 3 || p.Dirs != 2 {
+		t.Errorf("heartbeat counted %d files in %d dirs, want 3 in 2", p.Files, p.Dirs)
+	}
+
+	// No updates after stop
+	time.Sleep(10 * time.Millisecond)
+	if rec.count() != n {
+		t.Error("heartbeat continued after stop")
+	}
+}
+
+func TestDebugMessages(t *testing.T) {
+	root := writeTree(t, map[string]string{"a": "same", "skip/b": "same"})
+	if err := os.Link(filepath.Join(root, "a"), filepath.Join(root, "c")); err != nil {
+		t.Skip(err)
+	}
+	pat, err := ParsePattern("skip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &progressRecorder{}
+	f := New(2)
+	f.Sink = rec
+	f.Exclude = []Pattern{pat}
+	f.IgnoreHardlinks = true
+	collect(t, f, root)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	got := strings.Join(rec.debugs, "\n")
+	for _, want := range []string{
+		fmt.Sprintf("excluded %q", filepath.Join(root, "skip")),
+		"is a hard link to a seen file",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("debug messages %q lack %q", got, want)
+		}
+	}
+}
+
+func TestFilterSink(t *testing.T) {
+	tests := []struct {
+		level Level
+		want  []string
+	}{
+		{LevelDebug, []string{"DEBUG details", "INFO hello", "WARN careful"}},
+		{LevelInfo, []string{"INFO hello", "WARN careful"}},
+		{LevelWarn, []string{"WARN careful"}},
+		{LevelError, nil},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		sink := FilterSink(NewTextSink(&out), tt.level)
+		sink.Debug("details")
+		sink.Info("hello")
+		sink.Warning("careful")
+
+		got := out.String()
+		for _, w := range tt.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("level %d: %q lacks %q", tt.level, got, w)
+			}
+		}
+		if n := strings.Count(got, "\n"); n != len(tt.want) {
+			t.Errorf("level %d: got %d lines %q, want %d", tt.level, n, got, len(tt.want))
+		}
+	}
+}
+
+func TestJSONSinkInfo(t *testing.T) {
+	var out bytes.Buffer
+	NewJSONSink(&out).Info("hello")
+
+	var ev struct {
+		Event string
+		Data  struct{ Message string }
+	}
+	if err := json.Unmarshal(out.Bytes(), &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Event != "info" || ev.Data.Message != "hello" {
+		t.Errorf("got %+v, want info event", ev)
+	}
+}
+
+func TestJSONSinkEvents(t *testing.T) {
+	var out bytes.Buffer
+	sink := NewJSONSink(&out)
+	sink.Progress(Progress{Files: 3, Dirs: 1, BytesHashed: 42, Current: "a\xffb"})
+	sink.Debug("details")
+	sink.Warning("careful")
+	sink.Stats(Summary{Files: 3, Copies: 2, WastedSpace: 10, Elapsed: time.Second})
+
+	dec := json.NewDecoder(&out)
+	var events []string
+	for dec.More() {
+		var ev struct {
+			Event string
+			Time  time.Time
+			Data  json.RawMessage
+		}
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Time.IsZero() {
+			t.Errorf("%s event without time", ev.Event)
+		}
+		events = append(events, ev.Event)
+
+		switch ev.Event {
+		case "progress":
+			var p Progress
+			if err := json.Unmarshal(ev.Data, &p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Files != 3 || p.Dirs != 1 || p.BytesHashed != 42 || p.Current == "" {
+				t.Errorf("got progress %+v", p)
+			}
+		case "stats":
+			var s Summary
+			if err := json.Unmarshal(ev.Data, &s); err != nil {
+				t.Fatal(err)
+			}
+			if s.Files != 3 || s.Copies != 2 || s.WastedSpace != 10 || s.Elapsed != time.Second {
+				t.Errorf("got stats %+v", s)
+			}
+		}
+	}
+	if strings.Join(events, ",") != "progress,debug,warning,stats" {
+		t.Errorf("got events %q", events)
+	}
+}
+
+func TestTextSinkEvents(t *testing.T) {
+	var out bytes.Buffer
+	sink := NewTextSink(&out)
+	sink.Progress(Progress{Files: 3, Dirs: 1, BytesHashed: 42, Current: "a"})
+	sink.Stats(Summary{Files: 3, Dirs: 1, Copies: 2, Skipped: 1, BudgetExceeded: true, MaxBytesHashed: 100})
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines %q, want 2", len(lines), lines)
+	}
+	for _, want := range []string{"INFO heartbeat: examined 3 files in 1 directories, hashed 42 bytes", `processing "a"`} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("progress %q lacks %q", lines[0], want)
+		}
+	}
+	for _, want := range []string{"INFO stats: examined 3 files", "found 2 dups", "skipped 1 unreadable", "budget of 100 bytes exhausted"} {
+		if !strings.Contains(lines[1], want) {
+			t.Errorf("stats %q lacks %q", lines[1], want)
+		}
+	}
+}
+
+func TestStatsStruct(t *testing.T) {
+	root := writeTree(t, map[string]string{"a": "same", "d/b": "same", "c": "other"})
+
+	f := New(2)
+	f.Sink = NewTextSink(io.Discard)
+	collect(t, f, root)
+	f.SetTimeSpent(time.Second)
+
+	got := f.StatsStruct()
+	want := RunStats{TotalDirs: 2, TotalFiles: 3, TotalCopies: 2, TotalWastedSpace: 4, TotalTime: time.Second}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	data, err := got.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const wantJSON = `{"total_dirs":2,"total_files":3,"total_copies":2,"total_wasted_space":4,"total_time_ns":1000000000}`
+	if string(data) != wantJSON {
+		t.Errorf("got %s, want %s", data, wantJSON)
+	}
+}
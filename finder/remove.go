@@ -0,0 +1,119 @@
+package finder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/caelifer/dups/action"
+	"github.com/caelifer/dups/node"
+)
+
+// Removal describes a duplicate removed or replaced with a link, or to be in dry-run mode.
+type Removal struct {
+	Path string // Removed copy
+	Kept string // Copy kept in its place
+	Size int64  // Reclaimed bytes
+	Err  error  // Why the copy was skipped, if it was
+}
+
+// RemoveDuplicates removes all but one copy of each duplicate group found in paths,
+// keeping the copy preferred by keep. In dry-run mode nothing is removed. Copies changed
+// since they were hashed are skipped, and so are whole groups whose kept copy changed,
+// so the last good copy is never removed. Content of the kept copy and of each removed
+// one is verified once more right before removal. It returns all the removals in group
+// order.
+func (f *Finder) RemoveDuplicates(paths []string, keep KeepPolicy, dryRun bool) []Removal {
+	return f.replaceDuplicates(paths, keep, dryRun, nil, func(_, dup *node.Node) error {
+		return os.Remove(dup.Path)
+	})
+}
+
+// LinkDuplicates is like RemoveDuplicates, but replaces the copies with hard links to the
+// kept one. Copies on other devices are skipped. Copies already linked to the kept one
+// reclaim no space and are skipped too.
+func (f *Finder) LinkDuplicates(paths []string, keep KeepPolicy, dryRun bool) []Removal {
+	check := func(kept, dup *node.Node) error {
+		if kept.Ino != 0 && kept.Dev == dup.Dev && kept.Ino == dup.Ino {
+			return errors.New("already linked")
+		}
+		if kept.Dev != dup.Dev {
+			return errors.New("on another device")
+		}
+		return nil
+	}
+
+	return f.replaceDuplicates(paths, keep, dryRun, check, func(kept, dup *node.Node) error {
+		return action.Hardlink(kept.Path, dup.Path)
+	})
+}
+
+// Apply replace to all but one copy of each duplicate group which passes the optional
+// check. In dry-run mode, only the checks are done, without re-hashing the content.
+func (f *Finder) replaceDuplicates(paths []string, keep KeepPolicy, dryRun bool, check, replace func(kept, dup *node.Node) error) []Removal {
+	// Collect groups first, the scan must be over before anything is removed
+	var hashes []string
+	groups := make(map[string][]Dup)
+	_ = f.ForEachDuplicate(paths, func(d Dup) error {
+		if _, ok := groups[d.Group()]; !ok {
+			hashes = append(hashes, d.Group())
+		}
+		groups[d.Group()] = append(groups[d.Group()], d)
+		return nil // Never stop
+	})
+
+	var res []Removal
+	for _, hash := range hashes {
+		dups := groups[hash]
+		SortByPolicy(dups, keep)
+		kept := dups[0].Node
+
+		// Never lose the only intact copy
+		keptErr := unchanged(kept)
+		if keptErr == nil && !dryRun {
+			keptErr = sameHash(kept, kept.Hash)
+		}
+
+		for _, d := range dups[1:] {
+			r := Removal{Path: d.Path, Kept: kept.Path, Size: d.Size, Err: keptErr}
+			if r.Err == nil {
+				r.Err = unchanged(d.Node)
+			}
+			if r.Err == nil && check != nil {
+				r.Err = check(kept, d.Node)
+			}
+			if r.Err == nil && !dryRun {
+				// Last check of content
+				if r.Err = sameHash(d.Node, kept.Hash); r.Err == nil {
+					r.Err = replace(kept, d.Node)
+				}
+			}
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// Re-hash the file and check it still has the hash
+func sameHash(n *node.Node, hash string) error {
+	x := &node.Node{Path: n.Path, Size: n.Size}
+	if err := x.CalculateHash(); err != nil {
+		return err
+	}
+	if x.Hash != hash {
+		return fmt.Errorf("%s: content changed since hashed", n.Path)
+	}
+	return nil
+}
+
+// Check that file is still the same as when it was hashed
+func unchanged(n *node.Node) error {
+	fi, err := os.Lstat(n.Path)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() || fi.Size() != n.Size || !fi.ModTime().Equal(n.ModTime) {
+		return fmt.Errorf("%s: changed since hashed", n.Path)
+	}
+	return nil
+}
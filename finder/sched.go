@@ -0,0 +1,141 @@
+package finder
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/caelifer/scheduler"
+	"github.com/caelifer/scheduler/job"
+)
+
+// safeScheduler is a Scheduler decorator which stops using the wrapped Scheduler once
+// it panics (or if there is none) and runs jobs on a bounded goroutine pool instead.
+// Jobs which panic are reported and don't bring the process down.
+type safeScheduler struct {
+	sched  scheduler.Scheduler
+	failed uint32        // Set once the wrapped scheduler is unusable
+	pool   chan struct{} // Fallback pool slots
+	warn   func(msg string)
+}
+
+// Wrap sched with the fallback pool of up to n goroutines, reporting the fallback to warn
+func newSafeScheduler(sched scheduler.Scheduler, n int, warn func(msg string)) *safeScheduler {
+	s := &safeScheduler{sched: sched, pool: make(chan struct{}, n), warn: warn}
+	if sched == nil {
+		s.failed = 1
+	}
+	return s
+}
+
+// Schedule implements scheduler.Scheduler interface
+func (s *safeScheduler) Schedule(j job.Interface) {
+	j = s.guard(j)
+	if atomic.LoadUint32(&s.failed) == 0 && s.try(j) {
+		return
+	}
+
+	// Block until there is a free slot, as the wrapped scheduler would
+	s.pool <- struct{}{}
+	go func() {
+		defer func() { <-s.pool }()
+		j()
+	}()
+}
+
+// Shutdown implements scheduler.Scheduler interface
+func (s *safeScheduler) Shutdown() {
+	if atomic.LoadUint32(&s.failed) == 0 {
+		defer s.recover()
+		s.sched.Shutdown()
+	}
+}
+
+// Schedule on the wrapped scheduler, reporting whether it succeeded
+func (s *safeScheduler) try(j job.Interface) (ok bool) {
+	defer s.recover()
+	s.sched.Schedule(j)
+	return true
+}
+
+// Wrap job so that its panic is reported to warn instead of crashing the worker
+func (s *safeScheduler) guard(j job.Interface) job.Interface {
+	return func() {
+		defer func() {
+			if r := recover(); r != nil {
+				s.warn(fmt.Sprint("job failed: ", r))
+			}
+		}()
+		j()
+	}
+}
+
+// Switch to the fallback pool on panic
+func (s *safeScheduler) recover() {
+	if r := recover(); r != nil {
+		if atomic.CompareAndSwapUint32(&s.failed, 0, 1) {
+			s.warn(fmt.Sprint("scheduler failed, falling back to goroutine pool: ", r))
+		}
+	}
+}
+
+// queuedScheduler is a Scheduler decorator which accepts up to depth jobs ahead of the
+// wrapped Scheduler, so that scheduling doesn't block while workers are busy.
+type queuedScheduler struct {
+	sched scheduler.Scheduler
+	queue chan job.Interface
+	done  chan struct{} // Closed once the queue is drained
+}
+
+// Wrap sched with a queue of depth pending jobs
+func newQueuedScheduler(sched scheduler.Scheduler, depth int) *queuedScheduler {
+	s := &queuedScheduler{sched: sched, queue: make(chan job.Interface, depth), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		for j := range s.queue {
+			s.sched.Schedule(j)
+		}
+	}()
+	return s
+}
+
+// Schedule implements scheduler.Scheduler interface
+func (s *queuedScheduler) Schedule(j job.Interface) {
+	s.queue <- j // Will block when the queue is full
+}
+
+// Shutdown implements scheduler.Scheduler interface. Queued jobs are handed over to the
+// wrapped Scheduler first.
+func (s *queuedScheduler) Shutdown() {
+	close(s.queue)
+	<-s.done
+	s.sched.Shutdown()
+}
+
+// serialScheduler runs one job at a time on the goroutine scheduling it
+type serialScheduler struct {
+	mu sync.Mutex
+}
+
+// NewSerialScheduler returns a Scheduler which runs each job inline, one at a time.
+// Jobs never overlap, which makes races and ordering issues easier to debug. Use it
+// with NewWithScheduler.
+func NewSerialScheduler() scheduler.Scheduler {
+	return new(serialScheduler)
+}
+
+// Schedule implements scheduler.Scheduler interface
+func (s *serialScheduler) Schedule(j job.Interface) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j()
+}
+
+// Shutdown implements scheduler.Scheduler interface
+func (s *serialScheduler) Shutdown() {}
+
+// Sensible workers count for the fallback pool
+func defaultPoolSize() int {
+	return runtime.NumCPU()
+}
@@ -0,0 +1,170 @@
+package finder
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caelifer/scheduler"
+	"github.com/caelifer/scheduler/job"
+)
+
+// Scheduler which always panics
+type brokenScheduler struct{}
+
+func (brokenScheduler) Schedule(job.Interface) { panic("broken") }
+func (brokenScheduler) Shutdown()              {}
+
+func TestSafeSchedulerFallback(t *testing.T) {
+	var out bytes.Buffer
+	f := NewWithScheduler(brokenScheduler{})
+	f.Sink = NewTextSink(&out)
+
+	var wg sync.WaitGroup
+	ran := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		i := i
+		f.scheduler.Schedule(func() {
+			defer wg.Done()
+			ran <- i
+		})
+	}
+	wg.Wait()
+	close(ran)
+
+	if len(ran) != 10 {
+		t.Errorf("ran %d jobs, want 10", len(ran))
+	}
+	if got := out.String(); strings.Count(got, "falling back") != 1 {
+		t.Errorf("sink got %q, want a single fallback warning", got)
+	}
+}
+
+func TestSerialScheduler(t *testing.T) {
+	s := NewSerialScheduler()
+	defer s.Shutdown()
+
+	// Jobs run inline, in order
+	var order []int
+	for i := 0; i < 5; i++ {
+		i := i
+		s.Schedule(func() { order = append(order, i) })
+	}
+	for i, n := range order {
+		if n != i {
+			t.Fatalf("jobs ran in order %v", order)
+		}
+	}
+
+	// Jobs scheduled from many goroutines never overlap
+	var (
+		wg      sync.WaitGroup
+		running int32
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go s.Schedule(func() {
+			defer wg.Done()
+			if atomic.AddInt32(&running, 1) != 1 {
+				t.Error("jobs overlap")
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		})
+	}
+	wg.Wait()
+}
+
+func TestSerialSchedulerScan(t *testing.T) {
+	root := writeTree(t, map[string]string{"a/x": "one", "a/y": "one", "b/x": "two", "b/y": "two", "c": "three"})
+
+	f := NewWithScheduler(NewSerialScheduler())
+	f.Sink = NewTextSink(io.Discard)
+
+	dups := collect(t, f, root)
+	if len(dups) != 4 {
+		t.Fatalf("found %d dups, want 4", len(dups))
+	}
+}
+
+func TestSafeSchedulerJobPanic(t *testing.T) {
+	for name, sched := range map[string]scheduler.Scheduler{"scheduler": scheduler.New(2), "fallback": nil} {
+		rec := new(progressRecorder)
+		f := NewWithScheduler(sched)
+		f.Sink = rec
+
+		var wg sync.WaitGroup
+		var ran int32
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			i := i
+			f.scheduler.Schedule(func() {
+				defer wg.Done()
+				if i%2 == 0 {
+					panic("job broke")
+				}
+				atomic.AddInt32(&ran, 1)
+			})
+		}
+		wg.Wait()
+
+		if ran != 2 {
+			t.Errorf("%s: %d jobs completed, want 2", name, ran)
+		}
+		warnings := rec.waitWarnings(2)
+		if len(warnings) != 2 || warnings[0] != "job failed: job broke" || warnings[1] != warnings[0] {
+			t.Errorf("%s: got warnings %q, want two job failures", name, warnings)
+		}
+	}
+}
+
+func TestQueuedScheduler(t *testing.T) {
+	s := newQueuedScheduler(scheduler.New(1), 3)
+
+	// Busy worker holds one job and the dispatcher another, the queue takes the rest
+	var wg sync.WaitGroup
+	release := make(chan struct{})
+	var ran int32
+	scheduled := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			s.Schedule(func() {
+				defer wg.Done()
+				<-release
+				atomic.AddInt32(&ran, 1)
+			})
+		}
+		close(scheduled)
+	}()
+
+	select {
+	case <-scheduled:
+	case <-time.After(10 * time.Second):
+		t.Fatal("scheduling blocked with free queue slots")
+	}
+	close(release)
+	wg.Wait()
+	s.Shutdown()
+
+	if ran != 5 {
+		t.Errorf("ran %d jobs, want 5", ran)
+	}
+}
+
+func TestQueueDepthScan(t *testing.T) {
+	root := writeTree(t, map[string]string{"a/x": "one", "a/y": "one", "b/x": "two", "b/y": "two", "c": "three"})
+
+	f := NewWithOptions(Options{Workers: 2, QueueDepth: 4})
+	f.Sink = NewTextSink(io.Discard)
+
+	dups := collect(t, f, root)
+	if len(dups) != 4 {
+		t.Fatalf("found %d dups, want 4", len(dups))
+	}
+}
@@ -0,0 +1,106 @@
+package finder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/caelifer/dups/fstree"
+)
+
+// SymlinkDup describes one of several symbolic links resolving to the same target.
+type SymlinkDup struct {
+	Target string // Absolute target path
+	Count  int    // Number of links to the target
+	Path   string // Link path
+}
+
+// Pretty printer for the report
+func (d SymlinkDup) String() string {
+	return fmt.Sprintf("%q:%d:%q", d.Target, d.Count, d.Path)
+}
+
+// SymlinkDuplicates finds symbolic links in the provided paths pointing to the same
+// target. Relative targets are resolved against the directory of the link, so links
+// are grouped by the absolute target path, whether or not it exists. Links themselves
+// are never followed.
+func (f *Finder) SymlinkDuplicates(paths []string) []SymlinkDup {
+	f.startScan(context.Background())
+	sink := f.sink()
+
+	var mu sync.Mutex
+	byTarget := make(map[string][]string)
+
+	opts := f.walkOptions()
+	opts.Symlinks = fstree.NoFollow
+	for _, p := range paths {
+		err := fstree.WalkWithOptions(f.scheduler, p, opts, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				sink.Warning(err.Error())
+				return nil
+			}
+			f.current.Store(path)
+
+			if info.IsDir() {
+				atomic.AddUint64(&f.totalDirs, 1)
+				return nil
+			}
+			if info.Mode()&os.ModeSymlink == 0 {
+				return nil
+			}
+			atomic.AddUint64(&f.totalFiles, 1)
+
+			target, err := linkTarget(path)
+			if err != nil {
+				sink.Warning(err.Error())
+				return nil
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			byTarget[target] = append(byTarget[target], path)
+			return nil
+		})
+
+		// Go on with other paths
+		if err != nil {
+			sink.Warning(err.Error())
+		}
+	}
+
+	var res []SymlinkDup
+	for target, links := range byTarget {
+		if len(links) < 2 {
+			continue
+		}
+		atomic.AddUint64(&f.totalCopies, uint64(len(links)))
+		for _, link := range links {
+			res = append(res, SymlinkDup{Target: target, Count: len(links), Path: link})
+		}
+	}
+
+	// Stable output
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Target != res[j].Target {
+			return res[i].Target < res[j].Target
+		}
+		return res[i].Path < res[j].Path
+	})
+	return res
+}
+
+// Get absolute target path of the link
+func linkTarget(path string) (string, error) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	return filepath.Abs(target)
+}
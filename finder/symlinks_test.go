@@ -0,0 +1,52 @@
+package finder
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSymlinkDuplicates(t *testing.T) {
+	root := writeTree(t, map[string]string{"target": "t", "other": "o", "d/e/f": "f"})
+	target := filepath.Join(root, "target")
+	links := map[string]string{
+		"l1":   "target",
+		"d/l2": filepath.Join("..", "target"),
+		"l3":   target,
+		"l4":   "other",
+		"m1":   "missing",
+		"d/m2": filepath.Join("..", "missing"),
+	}
+	for name, to := range links {
+		if err := os.Symlink(to, filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Skip("symbolic links not supported:", err)
+		}
+	}
+
+	f := New(2)
+	f.Sink = NewTextSink(io.Discard)
+	got := f.SymlinkDuplicates([]string{root})
+
+	missing := filepath.Join(root, "missing")
+	want := []SymlinkDup{
+		{missing, 2, filepath.Join(root, "d", "m2")},
+		{missing, 2, filepath.Join(root, "m1")},
+		{target, 3, filepath.Join(root, "d", "l2")},
+		{target, 3, filepath.Join(root, "l1")},
+		{target, 3, filepath.Join(root, "l3")},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %v, want %v", got[i], want[i])
+		}
+	}
+
+	// Links are counted as files, their groups as copies
+	if s := f.Summary(); s.Files != 6 || s.Copies != 5 {
+		t.Errorf("counted %d files and %d copies, want 6 and 5", s.Files, s.Copies)
+	}
+}
@@ -0,0 +1,97 @@
+package finder
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/caelifer/dups/node"
+)
+
+// Confirm groups of files with the same hash byte for byte, see CompareBytes. Groups
+// are compared in parallel. Mismatching members are split into groups told apart by
+// Dup.Split and keyed by Dup.Group. It returns new groups along with their counts.
+func (f *Finder) compareGroups(byHash map[string][]Dup, counts map[string]int) (map[string][]Dup, map[string]int) {
+	newGroups := make(map[string][]Dup)
+	newCounts := make(map[string]int)
+	var mu sync.Mutex
+
+	wg := new(sync.WaitGroup) // Heap
+	for hash, dups := range byHash {
+		wg.Add(1)
+		go func(hash string, dups []Dup) {
+			f.scheduler.Schedule(func() {
+				defer wg.Done() // Signal done
+				split := f.compareGroup(dups)
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				// Members not kept due to MaxGroupMembers stay with the first group
+				untracked := counts[hash] - len(dups)
+				kept := 0
+				for i, g := range split {
+					count := len(g)
+					if i == 0 {
+						count += untracked
+					}
+					for j := range g {
+						g[j].Split = i
+					}
+					key := g[0].Group()
+					if count < 2 {
+						continue // Unique after all
+					}
+					newGroups[key] = g
+					newCounts[key] = count
+					kept += count
+				}
+
+				// Not copies after all
+				if dropped := counts[hash] - kept; dropped > 0 {
+					atomic.AddUint64(&f.totalCopies, ^uint64(dropped-1))
+				}
+			})
+		}(hash, dups)
+	}
+	wg.Wait()
+
+	return newGroups, newCounts
+}
+
+// Split files with the same hash into groups of byte for byte identical files. Files
+// changed since hashed or failed to read are dropped with a warning.
+func (f *Finder) compareGroup(dups []Dup) [][]Dup {
+	sink := f.sink()
+
+	var groups [][]Dup
+next:
+	for _, d := range dups {
+		changed, err := d.Changed()
+		if err != nil {
+			sink.Warning(err.Error())
+			continue
+		}
+		if changed {
+			sink.Warning(fmt.Sprintf("%q changed since hashed, skipping", d.Path))
+			continue
+		}
+
+		for i, g := range groups {
+			same, err := node.SameContent(g[0].Path, d.Path)
+			if err != nil {
+				sink.Warning(err.Error())
+				continue next
+			}
+			if same {
+				groups[i] = append(g, d)
+				continue next
+			}
+		}
+		if len(groups) > 0 {
+			sink.Warning(fmt.Sprintf("%q has the same hash as %q, but different content", d.Path, groups[0][0].Path))
+		}
+		groups = append(groups, []Dup{d})
+	}
+	return groups
+}
@@ -0,0 +1,106 @@
+package fstree
+
+import (
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/caelifer/scheduler"
+)
+
+// Directory information on the given device
+type devInfo uint64
+
+func (devInfo) Name() string       { return "d" }
+func (devInfo) Size() int64        { return 0 }
+func (devInfo) Mode() fs.FileMode  { return fs.ModeDir | 0755 }
+func (devInfo) ModTime() time.Time { return time.Time{} }
+func (devInfo) IsDir() bool        { return true }
+func (d devInfo) Sys() interface{} { return &syscall.Stat_t{Dev: uint64(d), Ino: 1} }
+
+// Directory information without the device
+type noSysInfo struct{ devInfo }
+
+func (noSysInfo) Sys() interface{} { return nil }
+
+func TestSameDevice(t *testing.T) {
+	root, ok := keyOf(devInfo(1))
+	if !ok || root.dev != 1 {
+		t.Fatalf("keyOf gave %+v, %v, want device 1", root, ok)
+	}
+	if _, ok := keyOf(noSysInfo{}); ok {
+		t.Error("keyOf gave a key without system information")
+	}
+
+	tests := []struct {
+		name     string
+		oneFS    bool
+		haveRoot bool
+		info     os.FileInfo
+		want     bool
+	}{
+		{"same device", true, true, devInfo(1), true},
+		{"other device", true, true, devInfo(2), false},
+		{"other device allowed", false, true, devInfo(2), true},
+		{"root device unknown", true, false, devInfo(2), true},
+		{"device unknown", true, true, noSysInfo{}, true},
+	}
+
+	for _, tt := range tests {
+		w := &walker{opts: Options{OneFileSystem: tt.oneFS}, rootKey: root, haveRoot: tt.haveRoot}
+		if got := w.sameDevice(tt.info); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOneFileSystem(t *testing.T) {
+	fsys := fstest.MapFS{
+		".":         {Mode: fs.ModeDir | 0755, Sys: &syscall.Stat_t{Dev: 1, Ino: 1}},
+		"a":         {Mode: fs.ModeDir | 0755, Sys: &syscall.Stat_t{Dev: 1, Ino: 2}},
+		"a/x":       {Data: []byte("x")},
+		"mnt":       {Mode: fs.ModeDir | 0755, Sys: &syscall.Stat_t{Dev: 2, Ino: 1}},
+		"mnt/y":     {Data: []byte("y")},
+		"mnt/sub":   {Mode: fs.ModeDir | 0755, Sys: &syscall.Stat_t{Dev: 2, Ino: 2}},
+		"mnt/sub/z": {Data: []byte("z")},
+	}
+
+	for _, tt := range []struct {
+		oneFS bool
+		want  []string
+	}{
+		{false, []string{".", "a", "a/x", "mnt", "mnt/sub", "mnt/sub/z", "mnt/y"}},
+		{true, []string{".", "a", "a/x", "mnt"}},
+	} {
+		var (
+			mu  sync.Mutex
+			got []string
+		)
+		opts := Options{MaxDepth: NoDepthLimit, OneFileSystem: tt.oneFS}
+		err := walk(scheduler.New(2), ioFS{fsys}, ".", opts, func(path string, _ os.FileInfo, err error) error {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, path)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Mount point itself is reported, but not entered
+		sort.Strings(got)
+		if len(got) != len(tt.want) {
+			t.Fatalf("one file system %v: visited %q, want %q", tt.oneFS, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Fatalf("one file system %v: visited %q, want %q", tt.oneFS, got, tt.want)
+			}
+		}
+	}
+}
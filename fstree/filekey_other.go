@@ -0,0 +1,19 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package fstree
+
+import "os"
+
+// fileKey uniquely identifies a file on the system
+type fileKey struct{}
+
+// File identity is not available on this platform.
+func keyOf(info os.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
+
+// Devices are not known on this platform.
+func sameDev(a, b fileKey) bool {
+	return true
+}
@@ -0,0 +1,29 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package fstree
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileKey uniquely identifies a file on the system
+type fileKey struct {
+	dev uint64
+	ino uint64
+}
+
+// Get identity of the file described by info. Returns false if it is not available.
+func keyOf(info os.FileInfo) (fileKey, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileKey{}, false
+	}
+	return fileKey{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
+
+// Check whether files are on the same device
+func sameDev(a, b fileKey) bool {
+	return a.dev == b.dev
+}
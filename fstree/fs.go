@@ -0,0 +1,57 @@
+package fstree
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/caelifer/scheduler"
+)
+
+// WalkFS is like Walk, but walks the tree at root of the file system fsys, e.g. an
+// in-memory fstest.MapFS. Paths use forward slashes, as io/fs requires. Symbolic links
+// are never followed, since io/fs can't tell them from their targets.
+func WalkFS(sched scheduler.Scheduler, fsys fs.FS, root string, fn nodeFn) error {
+	return walk(sched, ioFS{fsys}, root, Options{MaxDepth: NoDepthLimit}, fn)
+}
+
+// fileSystem is what the walker needs to access files
+type fileSystem interface {
+	Lstat(name string) (os.FileInfo, error)
+	Stat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Join(dir, name string) string
+	Clean(name string) string
+}
+
+// osFS is the file system of the host
+type osFS struct{}
+
+func (osFS) Lstat(name string) (os.FileInfo, error)     { return os.Lstat(name) }
+func (osFS) Stat(name string) (os.FileInfo, error)      { return os.Stat(name) }
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+func (osFS) Clean(name string) string                   { return filepath.Clean(name) }
+
+// Use custom fast string concatenation routine
+func (osFS) Join(dir, name string) string {
+	return fastStringConcat(dir, os.PathSeparator, name)
+}
+
+// ioFS adapts fs.FS, which has no notion of symbolic links
+type ioFS struct {
+	fsys fs.FS
+}
+
+func (f ioFS) Lstat(name string) (os.FileInfo, error)     { return fs.Stat(f.fsys, name) }
+func (f ioFS) Stat(name string) (os.FileInfo, error)      { return fs.Stat(f.fsys, name) }
+func (f ioFS) ReadDir(name string) ([]fs.DirEntry, error) { return fs.ReadDir(f.fsys, name) }
+func (ioFS) Clean(name string) string                     { return path.Clean(name) }
+
+// Valid paths have no leading "./"
+func (ioFS) Join(dir, name string) string {
+	if dir == "." {
+		return name
+	}
+	return fastStringConcat(dir, '/', name)
+}
@@ -0,0 +1,226 @@
+package fstree
+
+import (
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/caelifer/scheduler"
+)
+
+// Synthetic tree shared by the tests
+var testFS = fstest.MapFS{
+	"a/x":     {Data: []byte("x")},
+	"a/y":     {Data: []byte("y")},
+	"a/c/z":   {Data: []byte("z")},
+	"b/w":     {Data: []byte("w")},
+	"top":     {Data: []byte("top")},
+	"empty":   {Mode: fs.ModeDir},
+	"d/e/f/g": {Data: []byte("g")},
+}
+
+func TestWalkFS(t *testing.T) {
+	sched := scheduler.New(4)
+
+	tests := []struct {
+		name  string
+		root  string
+		opts  Options
+		want  []string
+		order bool // Compare visitation order, not only visited paths
+	}{
+		{
+			name: "parallel",
+			root: ".",
+			opts: Options{MaxDepth: NoDepthLimit},
+			want: []string{".", "a", "a/c", "a/c/z", "a/x", "a/y", "b", "b/w", "d", "d/e", "d/e/f", "d/e/f/g", "empty", "top"},
+		},
+		{
+			name:  "depth-first",
+			root:  ".",
+			opts:  Options{Order: DepthFirst, MaxDepth: NoDepthLimit},
+			want:  []string{".", "a", "a/c", "a/c/z", "a/x", "a/y", "b", "b/w", "d", "d/e", "d/e/f", "d/e/f/g", "empty", "top"},
+			order: true,
+		},
+		{
+			name:  "breadth-first",
+			root:  ".",
+			opts:  Options{Order: BreadthFirst, MaxDepth: NoDepthLimit},
+			want:  []string{".", "a", "b", "d", "empty", "top", "a/c", "a/x", "a/y", "b/w", "d/e", "a/c/z", "d/e/f", "d/e/f/g"},
+			order: true,
+		},
+		{
+			name: "subtree",
+			root: "a",
+			opts: Options{MaxDepth: NoDepthLimit},
+			want: []string{"a", "a/c", "a/c/z", "a/x", "a/y"},
+		},
+		{
+			name: "single file",
+			root: "top",
+			want: []string{"top"},
+		},
+		{
+			name:  "resume",
+			root:  ".",
+			opts:  Options{Order: DepthFirst, ResumeFrom: "b/w", MaxDepth: NoDepthLimit},
+			want:  []string{".", "b", "b/w", "d", "d/e", "d/e/f", "d/e/f/g", "empty", "top"},
+			order: true,
+		},
+		{
+			name:  "root only",
+			root:  ".",
+			opts:  Options{Order: DepthFirst},
+			want:  []string{"."},
+			order: true,
+		},
+		{
+			name:  "max depth 1",
+			root:  ".",
+			opts:  Options{Order: DepthFirst, MaxDepth: 1},
+			want:  []string{".", "a", "b", "d", "empty", "top"},
+			order: true,
+		},
+		{
+			name:  "max depth 2",
+			root:  ".",
+			opts:  Options{Order: DepthFirst, MaxDepth: 2},
+			want:  []string{".", "a", "a/c", "a/x", "a/y", "b", "b/w", "d", "d/e", "empty", "top"},
+			order: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu  sync.Mutex
+				got []string
+			)
+			err := walk(sched, ioFS{testFS}, tt.root, tt.opts, func(path string, _ os.FileInfo, err error) error {
+				mu.Lock()
+				defer mu.Unlock()
+				got = append(got, path)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("visited %d nodes %q, want %d %q", len(got), got, len(tt.want), tt.want)
+			}
+			want := tt.want
+			if !tt.order {
+				sort.Strings(got)
+				want = append([]string(nil), want...)
+				sort.Strings(want)
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("visited %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWalkFSMissingRoot(t *testing.T) {
+	err := WalkFS(scheduler.New(1), testFS, "nope", func(string, os.FileInfo, error) error {
+		t.Error("walk function called")
+		return nil
+	})
+	if err == nil {
+		t.Fatal("no error for missing root")
+	}
+}
+
+// File system recording which entries had their information read, failing for "bad"
+type statRecorder struct {
+	ioFS
+	mu   sync.Mutex
+	read []string
+}
+
+func (f *statRecorder) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := f.ioFS.ReadDir(name)
+	for i, e := range entries {
+		entries[i] = recordedEntry{e, f.Join(name, e.Name()), f}
+	}
+	return entries, err
+}
+
+type recordedEntry struct {
+	fs.DirEntry
+	path string
+	fsys *statRecorder
+}
+
+func (e recordedEntry) Info() (fs.FileInfo, error) {
+	e.fsys.mu.Lock()
+	e.fsys.read = append(e.fsys.read, e.path)
+	e.fsys.mu.Unlock()
+	if e.Name() == "bad" {
+		return nil, fs.ErrNotExist // Like an entry removed since read
+	}
+	return e.DirEntry.Info()
+}
+
+func TestSkipBeforeStat(t *testing.T) {
+	fsys := &statRecorder{ioFS: ioFS{fstest.MapFS{
+		"a/x": {Data: []byte("x")},
+		"bad": {Data: []byte("bad")},
+		"top": {Data: []byte("top")},
+	}}}
+
+	var (
+		mu      sync.Mutex
+		visited []string
+		failed  []string
+	)
+	opts := Options{
+		MaxDepth: NoDepthLimit,
+		Skip: func(path string, _ fs.DirEntry) bool {
+			return path == "a" || path == "bad"
+		},
+		OnError: func(path string, _ error) error {
+			mu.Lock()
+			defer mu.Unlock()
+			failed = append(failed, path)
+			return nil
+		},
+	}
+	err := walk(scheduler.New(2), fsys, ".", opts, func(path string, _ os.FileInfo, err error) error {
+		mu.Lock()
+		defer mu.Unlock()
+		visited = append(visited, path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(visited)
+	if len(visited) != 2 || visited[0] != "." || visited[1] != "top" {
+		t.Errorf("visited %q, want . and top", visited)
+	}
+	if len(fsys.read) != 1 || fsys.read[0] != "top" {
+		t.Errorf("read information of %q, want only top", fsys.read)
+	}
+	if len(failed) != 0 {
+		t.Errorf("skipped entries failed: %q", failed)
+	}
+
+	// Without skipping, the unreadable entry fails
+	opts.Skip = nil
+	fsys.read = nil
+	err = walk(scheduler.New(2), fsys, ".", opts, func(string, os.FileInfo, error) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0] != "bad" {
+		t.Errorf("got failures %q, want bad", failed)
+	}
+}
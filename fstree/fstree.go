@@ -1,10 +1,10 @@
 package fstree
 
 import (
-	"io/ioutil"
-	"log"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/caelifer/scheduler"
@@ -15,45 +15,161 @@ import (
 // Helper type - matches parameter signature of filepath.Walk()
 type nodeFn func(path string, info os.FileInfo, err error) error
 
+// Order defines the order in which directories are traversed.
+type Order int
+
+const (
+	// Parallel reads directories concurrently in no particular order (default).
+	Parallel Order = iota
+	// BreadthFirst visits all entries of a level before descending to the next one.
+	BreadthFirst
+	// DepthFirst fully descends into each directory before visiting its next sibling.
+	DepthFirst
+)
+
+// SymlinkPolicy defines how symbolic links are handled.
+type SymlinkPolicy int
+
+const (
+	// NoFollow reports symbolic links as is, without following them (default).
+	NoFollow SymlinkPolicy = iota
+	// Follow resolves all symbolic links and descends into linked directories. Each
+	// directory and file is visited once, even if reachable through several links.
+	// Hard links are paths of their own and are all visited.
+	Follow
+	// FollowWithinRoot only follows links which resolve to a path under the walk root.
+	FollowWithinRoot
+)
+
+// NoDepthLimit is the Options.MaxDepth value which lets the walk descend to any depth.
+const NoDepthLimit = -1
+
+// Options controls walker behavior. Zero value provides the default behavior, except
+// that it only walks the root itself; set MaxDepth to NoDepthLimit to walk the tree.
+type Options struct {
+	Order    Order         // Directory traversal order
+	Symlinks SymlinkPolicy // Symbolic links handling
+
+	// ResumeFrom skips all entries ordered before this path, comparing paths component
+	// by component. Combined with DepthFirst order it allows to process a huge tree in
+	// chunks, resuming where the previous run stopped.
+	ResumeFrom string
+
+	// MaxDepth limits descent to that many levels below the walk root: 0 walks the root
+	// only, 1 the root and its entries, and so on. Directories at the last level are
+	// reported, but not read. Negative values, like NoDepthLimit, mean no limit.
+	MaxDepth int
+
+	// OneFileSystem stops the walk from descending into directories on other devices
+	// than the walk root, like find -xdev. Mount points themselves are still reported.
+	// It has no effect where device numbers are not available.
+	OneFileSystem bool
+
+	// Skip is called for each directory entry before its file information is read.
+	// Entries for which it returns true are dropped without calling the walk function,
+	// which saves a stat call per filtered out entry. Walk roots are never skipped.
+	Skip func(path string, entry fs.DirEntry) bool
+
+	// OnError is called for entries which can't be read or followed, and for errors
+	// returned by the walk function. If it returns an error, the walk stops and returns
+	// that error. By default such entries are skipped and the walk goes on.
+	OnError func(path string, err error) error
+}
+
 // Walk is a primary interface to this package. It matches signature of filepath.Walk().
+// As with filepath.Walk(), fn may return filepath.SkipDir to skip a directory.
 func Walk(sched scheduler.Scheduler, path string, fn nodeFn) error {
+	return WalkWithOptions(sched, path, Options{MaxDepth: NoDepthLimit}, fn)
+}
+
+// WalkWithOptions is like Walk but allows to customize walker behavior. Ordered
+// traversal processes directories sequentially, trading parallelism for a
+// deterministic visitation order.
+func WalkWithOptions(sched scheduler.Scheduler, path string, opts Options, fn nodeFn) error {
+	return walk(sched, osFS{}, path, opts, fn)
+}
+
+// Walk the tree at path of the file system fsys
+func walk(sched scheduler.Scheduler, fsys fileSystem, path string, opts Options, fn nodeFn) error {
 	// Create walker object
-	w := newWalker(sched, path)
+	w := newWalker(sched, fsys, path, opts)
+
+	// Resolve root to check where links point to
+	if opts.Symlinks == FollowWithinRoot {
+		root, err := realPath(path)
+		if err != nil {
+			return err
+		}
+		w.realRoot = root
+	}
+
+	// Remember device of the root to stay on
+	if opts.OneFileSystem {
+		if info, err := fsys.Stat(path); err == nil {
+			w.rootKey, w.haveRoot = keyOf(info)
+		}
+	}
 
 	// Construct node from provided path
-	info, err := os.Lstat(path)
+	info, err := fsys.Lstat(path)
 
 	// On success ...
 	if err == nil {
 		// Process node
-		err = w.walkNode(newNode(path, info), nil, fn)
+		err = w.walkNode(w.newNode(path, info), nil, fn)
+	}
+
+	// Process pending directories level by level
+	for len(w.queue) > 0 {
+		next := w.queue[0]
+		w.queue = w.queue[1:]
+		w.readDir(next, fn)
 	}
 
 	// Wait util all nodes are processed
 	w.wg.Wait()
 
+	// Aborted by the error policy
+	if abortErr := w.aborted(); abortErr != nil {
+		return abortErr
+	}
 	return err
 }
 
 type node struct {
-	path string
-	info os.FileInfo
+	path  string
+	info  os.FileInfo
+	depth int // Levels below the walk root
 }
 
-func newNode(path string, info os.FileInfo) *node {
-	return &node{path: filepath.Clean(path), info: info}
+func (w *walker) newNode(path string, info os.FileInfo) *node {
+	return &node{path: w.fsys.Clean(path), info: info}
 }
 
 type walker struct {
-	root  string
-	sched scheduler.Scheduler
-	wg    sync.WaitGroup
+	root     string
+	fsys     fileSystem
+	realRoot string  // Resolved absolute root path
+	rootKey  fileKey // Identity of the root, see OneFileSystem
+	haveRoot bool
+	sched    scheduler.Scheduler
+	opts     Options
+	queue    []*node // Pending directories for breadth-first traversal
+	wg       sync.WaitGroup
+
+	// Visited directories and files to detect cycles and repeats when following links
+	mu      sync.Mutex
+	visited map[fileKey]bool // Whether first reached through a symbolic link
+	err     error            // Error which stopped the walk
 }
 
-func newWalker(sched scheduler.Scheduler, root string) *walker {
+func newWalker(sched scheduler.Scheduler, fsys fileSystem, root string, opts Options) *walker {
 	return &walker{
-		root:  root,
-		sched: sched,
+		root:    root,
+		fsys:    fsys,
+		sched:   sched,
+		opts:    opts,
+		visited: make(map[fileKey]bool),
 	}
 }
 
@@ -62,21 +178,60 @@ func (w *walker) walkNode(node *node, err error, fn nodeFn) error {
 	w.wg.Add(1)
 	defer w.wg.Done()
 
+	// Stop early once aborted
+	if w.aborted() != nil {
+		return nil
+	}
+
+	// Skip entries before resume point
+	if w.opts.ResumeFrom != "" && pathLess(node.path, w.opts.ResumeFrom) {
+		return nil
+	}
+
+	// Resolve symbolic links according to the policy
+	linked := false
+	if node.info.Mode()&os.ModeSymlink != 0 && w.opts.Symlinks != NoFollow {
+		if info, ok := w.follow(node.path); ok {
+			node.info = info
+			linked = true
+		}
+	}
+
+	// Same file may be reachable through links, report it once
+	if node.info.Mode().IsRegular() && !w.firstVisit(node.info, linked) {
+		return nil
+	}
+
 	// Process node by calling client function
 	err = fn(node.path, node.info, err)
 
-	// ... then, recursively process directories
-	if node.info.IsDir() {
+	// Client asked to skip this directory
+	if err == filepath.SkipDir {
+		return nil
+	}
+	if err != nil {
+		w.fail(node.path, err)
+		return err
+	}
+
+	// ... then, recursively process directories once, unless too deep
+	if node.info.IsDir() && !w.tooDeep(node) && w.sameDevice(node.info) && w.firstVisit(node.info, linked) {
 		// Traverse directory in parallel using balancer with fixed number of workers to avoid FD exhaustion.
-		w.walkDir(node, err, fn)
+		w.walkDir(node, fn)
 	}
 
 	return err
 }
 
-func (w *walker) walkDir(node *node, err error, fn nodeFn) {
-	if err != nil {
-		log.Println("WARN", err)
+func (w *walker) walkDir(node *node, fn nodeFn) {
+	switch w.opts.Order {
+	case DepthFirst:
+		// Descend right away on the current goroutine
+		w.readDir(node, fn)
+		return
+	case BreadthFirst:
+		// Postpone until the current level is done
+		w.queue = append(w.queue, node)
 		return
 	}
 
@@ -88,31 +243,157 @@ func (w *walker) walkDir(node *node, err error, fn nodeFn) {
 	go func() {
 		w.sched.Schedule(func() {
 			defer w.wg.Done() // Signal done at the end of the function
+			w.readDir(node, fn)
+		})
+	}()
+	// log.Println("Done scheduling")
+}
 
-			// Read directory entries
-			dirents, err := ioutil.ReadDir(node.path)
-			if err != nil {
-				log.Println("WARN", err)
+func (w *walker) readDir(node *node, fn nodeFn) {
+	// Read directory entries, file information is only read on demand
+	dirents, err := w.fsys.ReadDir(node.path)
+	if err != nil {
+		w.fail(node.path, err)
 
-				// early termination if we cannot read directory
-				return
-			}
+		// early termination if we cannot read directory
+		return
+	}
 
-			// Read all entries in current directory
-			for _, entry := range dirents {
-				// path := node.path + string(os.PathSeparator) + entry.Name()
+	// Read all entries in current directory
+	for _, entry := range dirents {
+		path := w.fsys.Join(node.path, entry.Name())
 
-				// Use custom fast string concatenation rutine
-				path := fastStringConcat(node.path, os.PathSeparator, entry.Name())
+		// Drop filtered out entries before stat
+		if w.opts.Skip != nil && w.opts.Skip(path, entry) {
+			continue
+		}
 
-				// Process node, ignore errors
-				if err := w.walkNode(newNode(path, entry), nil, fn); err != nil {
-					log.Printf("unable to walk %q: %v", path, err)
-				}
-			}
-		})
-	}()
-	// log.Println("Done scheduling")
+		info, err := entry.Info()
+		if err != nil {
+			w.fail(path, err)
+			continue
+		}
+
+		// Process node, errors are already handled
+		child := w.newNode(path, info)
+		child.depth = node.depth + 1
+		_ = w.walkNode(child, nil, fn)
+	}
+}
+
+// Returns information about the link target if the link should be followed.
+func (w *walker) follow(path string) (os.FileInfo, bool) {
+	if w.opts.Symlinks == FollowWithinRoot {
+		target, err := realPath(path)
+		if err != nil {
+			w.fail(path, err)
+			return nil, false
+		}
+		// Ignore links pointing outside
+		if target != w.realRoot && !strings.HasPrefix(target, w.realRoot+string(os.PathSeparator)) {
+			return nil, false
+		}
+	}
+
+	info, err := w.fsys.Stat(path)
+	if err != nil {
+		w.fail(path, err)
+		return nil, false
+	}
+	return info, true
+}
+
+// Check whether directory is at the depth limit
+func (w *walker) tooDeep(node *node) bool {
+	return w.opts.MaxDepth >= 0 && node.depth >= w.opts.MaxDepth
+}
+
+// Check whether directory is on the root device, if that is required
+func (w *walker) sameDevice(info os.FileInfo) bool {
+	if !w.opts.OneFileSystem || !w.haveRoot {
+		return true
+	}
+	key, ok := keyOf(info)
+	return !ok || sameDev(key, w.rootKey)
+}
+
+// Handle error according to the error policy
+func (w *walker) fail(path string, err error) {
+	if w.opts.OnError == nil {
+		return // Skip silently
+	}
+
+	if err := w.opts.OnError(path, err); err != nil {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.err == nil {
+			w.err = err
+		}
+	}
+}
+
+// Get error which stopped the walk, if any
+func (w *walker) aborted() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Check that directory or file was not visited before. This guards against cycles and
+// walking the same directory or reporting the same file twice when following links.
+// Hard links are paths of their own, so a file is only collapsed if one of its visits
+// is through a symbolic link.
+func (w *walker) firstVisit(info os.FileInfo, linked bool) bool {
+	if w.opts.Symlinks == NoFollow {
+		return true // no links - no cycles
+	}
+
+	key, ok := keyOf(info)
+	if !ok {
+		return true // cannot tell
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	viaLink, seen := w.visited[key]
+	if !seen {
+		w.visited[key] = linked
+		return true
+	}
+	return info.Mode().IsRegular() && !linked && !viaLink
+}
+
+// Compare paths component by component, which matches the depth-first visitation order.
+// Ancestors of b are not considered less, as they must be walked to reach b.
+func pathLess(a, b string) bool {
+	ac, bc := components(a), components(b)
+
+	for i := 0; i < len(ac) && i < len(bc); i++ {
+		if ac[i] != bc[i] {
+			return ac[i] < bc[i]
+		}
+	}
+	return false // a is either an ancestor, equal or descendant of b
+}
+
+// Split path into its components. The current directory has none, so it's an ancestor of
+// all relative paths.
+func components(path string) []string {
+	path = filepath.Clean(path)
+	if path == "." {
+		return nil
+	}
+	return strings.Split(path, string(os.PathSeparator))
+}
+
+// Helper to get absolute path with all links resolved
+func realPath(path string) (string, error) {
+	p, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(p)
 }
 
 // Little helper for specialized fast string + byte + string concatenation
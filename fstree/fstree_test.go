@@ -0,0 +1,237 @@
+package fstree
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caelifer/scheduler"
+)
+
+// Create a temporary tree with i nodes below its root, which is returned: nested
+// directories with a single file in the innermost one. Walk visits i+1 nodes in it.
+func makeTree(t *testing.T, i int) string {
+	t.Helper()
+	root := t.TempDir()
+	if i == 0 {
+		return root
+	}
+	dir := root
+	for d := 1; d < i; d++ {
+		dir = filepath.Join(dir, "d"+strconv.Itoa(d))
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "f"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestWalkCount(t *testing.T) {
+	sched := scheduler.New(4)
+
+	for i := 0; i < 10; i++ {
+		root := makeTree(t, i)
+
+		var visited int32
+		err := Walk(sched, root, func(string, os.FileInfo, error) error {
+			atomic.AddInt32(&visited, 1)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if visited != int32(i+1) {
+			t.Errorf("tree %d: visited %d nodes, want %d", i, visited, i+1)
+		}
+	}
+}
+
+func TestPathLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"a", "b", true},
+		{"b", "a", false},
+		{"a/z", "b", true},
+		{"a", "a/b", false},   // Ancestor
+		{"a/b", "a", false},   // Descendant
+		{"a/b", "a/b", false}, // Equal
+		{".", "b/w", false},   // Current directory is an ancestor
+		{"./a", "b", true},
+		{"a-b", "a/b", false}, // By component, not byte
+	}
+
+	for _, tt := range tests {
+		if got := pathLess(tt.a, tt.b); got != tt.want {
+			t.Errorf("pathLess(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestWalkOrder(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"b/y", "a/x", "c"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sched := scheduler.New(4)
+
+	tests := []struct {
+		order Order
+		want  []string
+	}{
+		{DepthFirst, []string{".", "a", "a/x", "b", "b/y", "c"}},
+		{BreadthFirst, []string{".", "a", "b", "c", "a/x", "b/y"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		err := WalkWithOptions(sched, root, Options{Order: tt.order, MaxDepth: NoDepthLimit}, func(path string, _ os.FileInfo, err error) error {
+			rel, _ := filepath.Rel(root, path)
+			got = append(got, filepath.ToSlash(rel))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("order %d: visited %q, want %q", tt.order, got, tt.want)
+		}
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	for _, dir := range []string{filepath.Join(root, "dir"), filepath.Join(base, "outside")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{filepath.Join(root, "dir", "f"), filepath.Join(base, "outside", "g")} {
+		if err := os.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"file": filepath.Join("dir", "f"),
+		"in":   "dir",
+		"loop": ".",
+		"out":  filepath.Join("..", "outside"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skip("symbolic links not supported:", err)
+		}
+	}
+
+	tests := []struct {
+		policy SymlinkPolicy
+		files  []string // Regular files visited
+	}{
+		{NoFollow, []string{"dir/f"}},
+		{Follow, []string{"dir/f", "out/g"}},
+		{FollowWithinRoot, []string{"dir/f"}},
+	}
+
+	for _, tt := range tests {
+		var files []string
+		opts := Options{Order: DepthFirst, Symlinks: tt.policy, MaxDepth: NoDepthLimit}
+		err := WalkWithOptions(scheduler.New(2), root, opts, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() {
+				rel, _ := filepath.Rel(root, path)
+				files = append(files, filepath.ToSlash(rel))
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatalf("policy %d: %v", tt.policy, err)
+		}
+		if strings.Join(files, ",") != strings.Join(tt.files, ",") {
+			t.Errorf("policy %d: visited files %q, want %q", tt.policy, files, tt.files)
+		}
+	}
+}
+
+func TestSymlinkCycle(t *testing.T) {
+	root := t.TempDir()
+	deep := filepath.Join(root, "a", "b", "c")
+	if err := os.MkdirAll(deep, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(deep, "f"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Links to the parent, an ancestor further up and the root
+	for name, target := range map[string]string{"up": "..", "upup": filepath.Join("..", "..", ".."), "root": root} {
+		if err := os.Symlink(target, filepath.Join(deep, name)); err != nil {
+			t.Skip("symbolic links not supported:", err)
+		}
+	}
+
+	for _, order := range []Order{Parallel, DepthFirst, BreadthFirst} {
+		for _, policy := range []SymlinkPolicy{Follow, FollowWithinRoot} {
+			done := make(chan int32)
+			go func() {
+				var files int32
+				opts := Options{Order: order, Symlinks: policy, MaxDepth: NoDepthLimit}
+				_ = WalkWithOptions(scheduler.New(2), root, opts, func(_ string, info os.FileInfo, err error) error {
+					if err == nil && info.Mode().IsRegular() {
+						atomic.AddInt32(&files, 1)
+					}
+					return err
+				})
+				done <- files
+			}()
+
+			select {
+			case files := <-done:
+				if files != 1 {
+					t.Errorf("order %d, policy %d: visited %d files, want 1", order, policy, files)
+				}
+			case <-time.After(30 * time.Second):
+				t.Fatalf("order %d, policy %d: walk didn't terminate", order, policy)
+			}
+		}
+	}
+}
+
+func TestFollowHardLinks(t *testing.T) {
+	root := t.TempDir()
+	a := filepath.Join(root, "a")
+	if err := os.WriteFile(a, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(a, filepath.Join(root, "b")); err != nil {
+		t.Skip("hard links not supported:", err)
+	}
+	if err := os.Symlink("a", filepath.Join(root, "l")); err != nil {
+		t.Skip("symbolic links not supported:", err)
+	}
+
+	// Hard links are reported each, the symbolic link only leads to a seen file
+	for _, policy := range []SymlinkPolicy{NoFollow, Follow} {
+		var files []string
+		opts := Options{Order: DepthFirst, Symlinks: policy, MaxDepth: NoDepthLimit}
+		err := WalkWithOptions(scheduler.New(2), root, opts, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() {
+				files = append(files, filepath.Base(path))
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(files, ",") != "a,b" {
+			t.Errorf("policy %d: visited files %q, want a and b", policy, files)
+		}
+	}
+}
@@ -1,5 +1,8 @@
 module github.com/caelifer/dups
 
-go 1.12
+go 1.16
 
-require github.com/caelifer/scheduler v0.0.0-20200417010307-fcce5f5da957
+require (
+	github.com/caelifer/scheduler v0.0.0-20200417010307-fcce5f5da957
+	golang.org/x/text v0.13.0
+)
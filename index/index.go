@@ -0,0 +1,117 @@
+package index
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Entry is a single catalogued file
+type Entry struct {
+	Hash string // Content hash
+	Size int64  // File size
+	Path string // File path
+
+	Digests map[string]string // Extra digests by algorithm name
+}
+
+// String formats Entry as a single index line. Extra digests follow the path as
+// name=value fields sorted by name.
+func (e Entry) String() string {
+	s := fmt.Sprintf("%s:%d:%q", e.Hash, e.Size, e.Path)
+
+	names := make([]string, 0, len(e.Digests))
+	for name := range e.Digests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s += ":" + name + "=" + e.Digests[name]
+	}
+	return s
+}
+
+// Index is a catalog of files by content hash
+type Index struct {
+	byHash map[string][]Entry
+	sizes  map[int64]bool
+}
+
+// New creates an empty Index.
+func New() *Index {
+	return &Index{
+		byHash: make(map[string][]Entry),
+		sizes:  make(map[int64]bool),
+	}
+}
+
+// Add puts entry to the index.
+func (idx *Index) Add(e Entry) {
+	idx.byHash[e.Hash] = append(idx.byHash[e.Hash], e)
+	idx.sizes[e.Size] = true
+}
+
+// Lookup returns all catalogued files with the provided hash.
+func (idx *Index) Lookup(hash string) []Entry {
+	return idx.byHash[hash]
+}
+
+// HasSize reports whether any catalogued file has the provided size. It allows to
+// skip hashing of files which cannot match anything in the index.
+func (idx *Index) HasSize(size int64) bool {
+	return idx.sizes[size]
+}
+
+// Load reads the index previously written as a sequence of Entry lines.
+func Load(r io.Reader) (*Index, error) {
+	idx := New()
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		e, err := ParseEntry(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		idx.Add(e)
+	}
+	return idx, scanner.Err()
+}
+
+// ParseEntry parses a single index line produced by Entry.String().
+func ParseEntry(line string) (Entry, error) {
+	var e Entry
+
+	// Hash and size never contain colons, the rest is a quoted path
+	fields := strings.SplitN(line, ":", 3)
+	if len(fields) != 3 {
+		return e, fmt.Errorf("malformed entry %q", line)
+	}
+
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return e, fmt.Errorf("bad size in %q: %v", line, err)
+	}
+	// Digest fields never contain quotes
+	end := strings.LastIndex(fields[2], `"`) + 1
+	path, err := strconv.Unquote(fields[2][:end])
+	if err != nil {
+		return e, fmt.Errorf("bad path in %q: %v", line, err)
+	}
+
+	// Optional extra digests
+	if rest := fields[2][end:]; rest != "" {
+		e.Digests = make(map[string]string)
+		for _, field := range strings.Split(strings.TrimPrefix(rest, ":"), ":") {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return e, fmt.Errorf("bad digest in %q", line)
+			}
+			e.Digests[kv[0]] = kv[1]
+		}
+	}
+
+	e.Hash, e.Size, e.Path = fields[0], size, path
+	return e, nil
+}
@@ -0,0 +1,60 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	entries := []Entry{
+		{Hash: "h1", Size: 1, Path: "plain"},
+		{Hash: "h1", Size: 1, Path: `with:colons and "quotes"`},
+		{Hash: "h2", Size: 22, Path: "with\ttab\nand newline"},
+		{Hash: "h3", Size: 333, Path: "bad\xffutf8", Digests: map[string]string{"md5": "m", "sha256": "s"}},
+	}
+
+	var out bytes.Buffer
+	for _, e := range entries {
+		fmt.Fprintln(&out, e)
+	}
+	idx, err := Load(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range entries {
+		var found bool
+		for _, got := range idx.Lookup(want.Hash) {
+			if got.Path != want.Path {
+				continue
+			}
+			found = true
+			if got.Size != want.Size || len(got.Digests) != len(want.Digests) {
+				t.Errorf("%q: got %+v, want %+v", want.Path, got, want)
+			}
+			for name, d := range want.Digests {
+				if got.Digests[name] != d {
+					t.Errorf("%q: digest %s is %q, want %q", want.Path, name, got.Digests[name], d)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%q not found", want.Path)
+		}
+		if !idx.HasSize(want.Size) {
+			t.Errorf("size %d not found", want.Size)
+		}
+	}
+	if idx.HasSize(4) {
+		t.Error("unknown size found")
+	}
+}
+
+func TestLoadMalformed(t *testing.T) {
+	for _, line := range []string{"h:1", "h:x:\"p\"", "h:1:p", "h:1:\"p\":md5"} {
+		if _, err := Load(bytes.NewBufferString(line + "\n")); err == nil {
+			t.Errorf("no error for %q", line)
+		}
+	}
+}
@@ -1,17 +1,33 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
 	"runtime/trace"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/caelifer/dups/action"
+	"github.com/caelifer/dups/cache"
 	"github.com/caelifer/dups/finder"
+	"github.com/caelifer/dups/fstree"
+	"github.com/caelifer/dups/index"
+	"github.com/caelifer/dups/mapreduce"
+	"github.com/caelifer/dups/node"
+	"github.com/caelifer/dups/report"
+	"github.com/caelifer/dups/storage"
 )
 
 // Scale number of workers 8 times the number of cores
@@ -19,6 +35,12 @@ const workerPoolMultiplier = 8 // Use eight times the available cores
 // Default workers count
 var defaultWorkerCount = runtime.NumCPU() * workerPoolMultiplier
 
+// Workers count for rotational storage where concurrent reads mostly add seeks
+const rotationalWorkerCount = 4
+
+// Number of bytes sampled to estimate compression ratio
+const compressionSampleSize = 1024 * 1024 // 1MiB
+
 // Start of execution
 func main() {
 	// Flags
@@ -26,16 +48,111 @@ func main() {
 		cpuprofile  = flag.String("cpuprofile", "", "write cpu profile to file")
 		memprofile  = flag.String("memprofile", "", "write memory profile to file")
 		tracefile   = flag.String("tracefile", "", "write trace output to a file")
-		workerCount = flag.Int("workers", defaultWorkerCount, "Number of parallel jobs")
+		workers     = flag.String("workers", strconv.Itoa(defaultWorkerCount), "Number of parallel jobs, or auto to pick by storage type of the first scanned path")
+		queueDepth  = flag.Int("queuedepth", 0, "accept up to N jobs ahead of busy workers (0 - hand each job directly over to a free worker)")
+		onlyExt     = flag.String("only-ext", "", "comma-separated list of file extensions to scan, e.g. jpg,png; case-insensitive, -exclude takes precedence. Default: all files")
+		outBuffer   = flag.Int("output-buffer", 0, "queue up to N results so that slow output doesn't stall scanning")
+		format      = flag.String("format", "text", "output format: text, tsv, csv (with a header row) or json (one object per line)")
+		columns     = flag.String("columns", strings.Join(report.DefaultColumns, ","), "comma-separated list of tsv and csv columns: hash, count, size, path, mtime, group-id, wasted-pct")
 		output      = flag.String("output", "-", "write output to a file. Default: STDOUT")
+		diskSize    = flag.Bool("size-on-disk", false, "account wasted space by allocated disk blocks instead of apparent size")
 		stats       = flag.Bool("stats", false, "display runtime statistics on STDERR")
+		mimeTypes   = flag.Bool("mime", false, "display wasted space by detected content type on STDERR")
+		nfc         = flag.Bool("nfc", false, "treat Unicode NFC/NFD variants of the same path as one file")
+		normalize   = flag.Bool("normalize", false, "normalize reported paths for comparison across runs/machines")
+		foldcase    = flag.Bool("foldcase", false, "lowercase reported paths (with -normalize); use for case-insensitive filesystems")
+		compression = flag.Bool("report-compression-ratio", false, "estimate compression ratio of each duplicate group from a sample")
+		minSize     = flag.String("minsize", "0", "skip files smaller than this, e.g. 10M; suffixes K, M, G, T are powers of 1024")
+		maxSize     = flag.String("maxsize", "", "skip files larger than this, e.g. 2G. Default: no limit")
+		ignoreLinks = flag.Bool("ignorehardlinks", false, "treat hard links to the same file as a single file instead of duplicates")
+		inclEmpty   = flag.Bool("includeempty", false, "report zero-length files as duplicates of each other")
+		maxDepth    = flag.Int("maxdepth", -1, "descend at most N levels below scanned paths; 0 - only the paths themselves, 1 - also their direct entries (-1 - no limit)")
+		symlinkDups = flag.Bool("symlinks", false, "report symbolic links pointing to the same target instead of files with the same content")
+		serial      = flag.Bool("serial", false, "run one job at a time, ignoring -workers; for debugging")
+		verbose     = flag.Bool("v", false, "report each unreadable path instead of their count")
+		xdev        = flag.Bool("xdev", false, "don't descend into directories on other filesystems, like find -xdev")
+		maxHashDeep = flag.Int("max-depth-hash", 0, "only deduplicate files within N levels below scanned paths; deeper files are only counted (0 - no limit)")
+		maxMembers  = flag.Int("max-group-members", 0, "report only this many members of larger duplicate groups (0 - no limit)")
+		follow      = flag.String("follow", "none", "symbolic links policy: none, all or root (follow only links resolving within scanned path)")
+		logLevel    = flag.String("loglevel", "info", "least severe diagnostics displayed on STDERR: debug, info, warn or error")
+		progressFmt = flag.String("progress-format", "", "format of progress, messages, warnings and stats on STDERR: text or json. Default: json with -format json, text otherwise")
+		heartbeat   = flag.Duration("heartbeat", 0, "log progress every interval, e.g. 30s (0 - disabled)")
+		resumeFrom  = flag.String("resume-from", "", "skip paths ordered before this one; implies -walk-order dfs unless set")
+		shards      = flag.Int("parallel-reduce", 1, "number of concurrent reducers for grouping stages")
+		inFlight    = flag.Int("max-in-flight", 0, "maximum number of files queued for hashing at once (0 - no limit)")
+		relWasted   = flag.Bool("report-relative-wasted", false, "annotate each duplicate group with its percentage of total wasted space")
+		group       = flag.Bool("group", false, "report members of each duplicate group together, sorted by path")
+		groupBlank  = flag.Bool("group-blank-lines", false, "separate duplicate groups with blank lines in text output")
+		topWasters  = flag.Int("top-wasters", 0, "report only N duplicate groups wasting the most space, biggest first")
+		walkOrder   = flag.String("walk-order", "", "directory traversal order: bfs or dfs. Default: parallel, unordered")
+		actionName  = flag.String("action", "", "action applied to duplicates: reflink. Default: report only")
+		fallback    = flag.String("action-fallback", "skip", "what to do when -action is unsupported: skip or hardlink")
+		consolidate = flag.String("consolidate-device", "", "collapse duplicates onto the device of this directory, removing copies on other devices")
+		canonical   = flag.Bool("emit-canonical-only", false, "list unique files and only the kept copy of each duplicate group")
+		writeIdx    = flag.Bool("write-index", false, "write index of all scanned files instead of reporting duplicates")
+		hashAlgo    = flag.String("hash", "sha1", "content hash algorithm: "+strings.Join(node.DigestNames(), ", ")+"; indexes only match with the same one")
+		checksums   = flag.String("checksum-algorithms-multi", "", "comma-separated list of extra digests computed in the same pass and written to -write-index: "+strings.Join(node.DigestNames(), ", "))
+		matchIdx    = flag.String("index", "", "report scanned files duplicating files catalogued in this index")
+		validateRep = flag.String("validate-report", "", "re-hash files listed in this report or index and flag stale entries")
+		verifyBytes = flag.Bool("verify-bytes", false, "compare files with the same hash byte for byte before reporting them as duplicates")
+		cacheFile   = flag.String("cache", "", "reuse hashes of files unchanged since the previous run from this file, and update it")
+		cross       = flag.Bool("cross", false, "only report duplicate groups with copies under at least two of the given paths")
+		fromList    = flag.String("from", "", "read paths to process from this file instead of walking directories, one per line; - for STDIN")
+		nulSep      = flag.Bool("0", false, "paths read with -from are separated by NUL characters, as printed by find -print0")
+		mergeRep    = flag.Bool("merge-reports", false, "merge text reports given instead of paths into one, regrouping duplicates by hash")
+		partialDups = flag.Bool("partial-dups", false, "report files which are truncated copies of larger files instead of exact duplicates")
+		previewFS   = flag.Bool("dedupe-preview-space-by-filesystem", false, "display reclaimable space by filesystem of kept copies on STDERR, along with free space")
+		simulate    = flag.Bool("dedupe-simulate", false, "report projected state of all duplicates after dedup: kept, linked and skipped files")
+		link        = flag.Bool("link", false, "replace all but the copy chosen by -keep of each duplicate group with hard links to it, like -action; see -dry-run")
+		remove      = flag.Bool("remove", false, "remove all but the copy chosen by -keep of each duplicate group, like -action; see -dry-run")
+		dryRun      = flag.Bool("dry-run", false, "only display what -action would do and how much space it would reclaim")
+		verifyAfter = flag.Bool("verify-after-action", false, "re-hash canonical files after -action, -remove, -link or -consolidate-device and abort if they changed")
+		keepPolicy  = flag.String("keep", "first", "comma-separated policies choosing the copy to keep: first, oldest, newest, shortest, dir:PATH")
+		minWasted   = flag.Int64("action-min-wasted", 0, "apply -action, -remove, -link or -consolidate-device only to groups wasting at least this many bytes")
+		prefixSize  = flag.Int64("prefix-hash", 4096, "hash first N bytes of same size files first and only fully hash those still matching (0 - disabled)")
+		readBuffer  = flag.String("read-buffer", "", "size of buffers used to read files while hashing, rounded up to memory pages. Default: 16 pages")
+		stride      = flag.Int64("sample-stride", 0, "approximate hashes of files larger than this from blocks read every this many bytes (0 - hash in full)")
+		sampleSize  = flag.Int64("sample-size", 64*1024, "size in bytes of each block read with -sample-stride")
+		verify      = flag.Bool("verify", false, "confirm duplicates found by -sample-stride with the full hash; required to modify files with -sample-stride")
+		maxHashed   = flag.Int64("max-bytes-hashed", 0, "stop after hashing this many bytes and report partial results (0 - no limit)")
 	)
 
+	// Aliases
+	flag.StringVar(onlyExt, "ext", "", "same as -only-ext")
+	flag.DurationVar(heartbeat, "progress", 0, "same as -heartbeat")
+	flag.IntVar(topWasters, "top", 0, "same as -top-wasters")
+
+	// Repeatable flags
+	var sources, targets stringList
+	flag.Var(&sources, "source", "report files under this path with content found nowhere under -target; repeatable")
+	flag.Var(&targets, "target", "path searched for content of -source files; repeatable")
+
+	var empty emptyMode
+	flag.Var(&empty, "empty", "report empty directories instead of duplicates; -empty=recursive also reports directories with only empty directories inside")
+
+	var excludes stringList
+	flag.Var(&excludes, "exclude", "skip paths matching this shell pattern (name, or path if it has a separator), or regexp with re: prefix; repeatable")
+
 	// First parse flags
 	flag.Parse()
 
+	// Process command line params
+	paths, err := scanPaths(flag.Args(), *fromList, *nulSep)
+	errHandle(err, "failed to read -from list")
+
+	// Validate workers count, picked for the first scanned path
+	var first string
+	if len(paths) > 0 {
+		first = paths[0]
+	}
+	workerCount, err := parseWorkers(*workers, first)
+	errHandle(err, "bad -workers value")
+	if *queueDepth < 0 {
+		errHandle(fmt.Errorf("need a non-negative depth, got %d", *queueDepth), "bad -queuedepth value")
+	}
+
 	// Prep runtime to use the workerCount real threads
-	runtime.GOMAXPROCS(*workerCount)
+	runtime.GOMAXPROCS(workerCount)
 
 	// CPU profile
 	if *cpuprofile != "" {
@@ -67,13 +184,100 @@ func main() {
 		defer trace.Stop()
 	}
 
-	// Process command line params
-	paths := flag.Args()
-	if len(paths) == 0 {
-		// Default is current directory
-		paths = []string{"."}
+	// Validate traversal order
+	order, err := parseWalkOrder(*walkOrder)
+	errHandle(err, "bad -walk-order value")
+	if *resumeFrom != "" && *walkOrder == "" {
+		// Resuming needs deterministic order
+		order = fstree.DepthFirst
+	}
+
+	// Validate symlinks policy
+	symlinks, err := parseSymlinkPolicy(*follow)
+	errHandle(err, "bad -follow value")
+
+	// Validate keep policy
+	keep, err := parseKeepPolicy(*keepPolicy)
+	errHandle(err, "bad -keep value")
+
+	// Validate action
+	act, err := parseAction(*actionName, *fallback)
+	errHandle(err, "bad -action value")
+	switch {
+	case *remove && *link, (*remove || *link) && act != nil:
+		errHandle(fmt.Errorf("-action, -remove and -link are mutually exclusive"), "bad command line")
+	case *remove:
+		act = action.Remove
+	case *link:
+		act = action.Hardlink
 	}
 
+	// Validate mode, all but scanning for duplicates are exclusive
+	err = checkModes([]mode{
+		{"-write-index", *writeIdx},
+		{"-emit-canonical-only", *canonical},
+		{"-validate-report", *validateRep != ""},
+		{"-merge-reports", *mergeRep},
+		{"-empty", empty != ""},
+		{"-symlinks", *symlinkDups},
+		{"-partial-dups", *partialDups},
+		{"-dedupe-simulate", *simulate},
+		{"-source/-target", len(sources) > 0 || len(targets) > 0},
+		{"-index", *matchIdx != ""},
+	})
+	errHandle(err, "bad command line")
+
+	// Validate progress format
+	if *progressFmt == "" && *format == "json" {
+		*progressFmt = "json" // Machine-readable all the way
+	}
+	sink, err := newSink(*progressFmt, os.Stderr)
+	errHandle(err, "bad -progress-format value")
+	level, err := finder.ParseLevel(*logLevel)
+	errHandle(err, "bad -loglevel value")
+	sink = finder.FilterSink(sink, level)
+
+	// Validate hash algorithm
+	hasher, err := node.Hasher(*hashAlgo)
+	errHandle(err, "bad -hash value")
+	node.SetHasher(hasher)
+
+	// Validate read buffer size
+	if *readBuffer != "" {
+		size, err := parseSize(*readBuffer)
+		errHandle(err, "bad -read-buffer value")
+		if size < 1 {
+			errHandle(fmt.Errorf("%d is not positive", size), "bad -read-buffer value")
+		}
+		node.SetReadBufferSize(int(size))
+	}
+
+	// Validate extra digests
+	var digests []string
+	if *checksums != "" {
+		digests = strings.Split(*checksums, ",")
+		errHandle(node.CheckDigests(digests), "bad -checksum-algorithms-multi value")
+	}
+
+	// Validate exclude patterns
+	var patterns []finder.Pattern
+	for _, s := range excludes {
+		p, err := finder.ParsePattern(s)
+		errHandle(err, "bad -exclude value")
+		patterns = append(patterns, p)
+	}
+
+	// Validate size range
+	minBytes, maxBytes, err := parseSizeRange(*minSize, *maxSize)
+	errHandle(err, "bad size range")
+
+	// Validate sampling
+	if *stride > 0 && (*sampleSize < 1 || *sampleSize > *stride) {
+		errHandle(fmt.Errorf("need 0 < %d <= %d", *sampleSize, *stride), "bad -sample-size value")
+	}
+	destructive := act != nil || *consolidate != ""
+	errHandle(checkSampling(*stride, *verify, destructive), "bad -sample-stride value")
+
 	// Get output writer
 	out, err := getOutput(*output)
 	errHandle(err, "failed to create output file")
@@ -85,21 +289,361 @@ func main() {
 	// Trace time spent
 	t1 := time.Now()
 
-	// Find all duplicate files and report to output
-	find := finder.New(*workerCount)
-	for d := range find.AllDuplicateFiles(paths) {
-		fmt.Fprintln(out, d)
+	// Build reporter
+	rep, err := newReporter(*format, strings.Split(*columns, ","), *groupBlank, out)
+	errHandle(err, "failed to create reporter")
+	if *normalize {
+		rep = report.Normalize(rep, *foldcase)
+	}
+	if *group {
+		rep = report.Grouped(rep)
+	}
+	if *topWasters > 0 {
+		rep = report.TopWasters(rep, *topWasters)
+	}
+	if *relWasted {
+		// Outermost, so shares are relative to all groups, not just the top ones
+		rep = report.RelativeWasted(rep)
+	}
+
+	// Configure finder
+	find := finder.NewWithOptions(finder.Options{Workers: workerCount, QueueDepth: *queueDepth})
+	if *serial {
+		find = finder.NewWithScheduler(finder.NewSerialScheduler())
+	}
+	find.Sink = sink
+	find.Digests = digests
+	find.MaxBytesHashed = *maxHashed
+	find.PrefixHashSize = *prefixSize
+	find.SampleStride = *stride
+	find.SampleSize = *sampleSize
+	find.VerifySampled = *verify
+	find.WalkOrder = order
+	find.Symlinks = symlinks
+	find.ResumeFrom = *resumeFrom
+	find.UseDiskSize = *diskSize
+	find.MaxGroupMembers = *maxMembers
+	find.MaxDepth = *maxDepth
+	find.OneFileSystem = *xdev
+	find.Verbose = *verbose
+	find.MaxHashDepth = *maxHashDeep
+	find.MinSize = minBytes
+	find.MaxSize = maxBytes
+	find.IncludeEmpty = *inclEmpty
+	find.Exclude = patterns
+	find.IgnoreHardlinks = *ignoreLinks
+	find.NormalizeUnicode = *nfc
+	find.DetectContentTypes = *mimeTypes
+	find.ReduceShards = *shards
+	find.MaxInFlight = *inFlight
+	find.TimeStages = *stats
+	find.NoRecurse = *fromList != ""
+	find.CrossRootsOnly = *cross
+	find.CompareBytes = *verifyBytes
+
+	// Load hashes of the previous run
+	var hashCache *cache.Cache
+	if *cacheFile != "" {
+		hashCache, err = loadCache(*cacheFile, *hashAlgo)
+		errHandle(err, "failed to load -cache")
+		find.Cache = hashCache
+	}
+	if *onlyExt != "" {
+		find.OnlyExtensions = strings.Split(*onlyExt, ",")
+	}
+	if *compression {
+		find.CompressionSample = compressionSampleSize
+	}
+
+	// Show we are alive
+	if *heartbeat > 0 {
+		stop := find.StartHeartbeat(*heartbeat)
+		defer stop()
+	}
+
+	switch {
+	case *writeIdx:
+		// Catalog all files
+		err = writeIndex(find.AllFiles(paths, nil), out)
+		errHandle(err, "failed to write index")
+
+	case *canonical:
+		// Minimal set of files covering all content
+		err = emitCanonical(find, paths, keep, out)
+		errHandle(err, "failed to write output")
+
+	case *validateRep != "":
+		// Re-verify old report
+		err = validateReport(sink, find, *validateRep, out)
+		errHandle(err, "failed to validate report")
+
+	case *mergeRep:
+		// Combine previous reports
+		err = mergeReports(sink, paths, rep)
+		errHandle(err, "failed to merge reports")
+
+	case empty != "":
+		// Leftover directories
+		err = writeLines(find.EmptyDirs(paths, empty == "recursive"), out)
+		errHandle(err, "failed to write output")
+
+	case *symlinkDups:
+		// Redundant links
+		err = writeSymlinkDups(find.SymlinkDuplicates(paths), out)
+		errHandle(err, "failed to write output")
+
+	case *partialDups:
+		// Truncated copies
+		err = writePartialDups(find.PartialDuplicates(paths), out)
+		errHandle(err, "failed to write output")
+
+	case *simulate:
+		// Project outcome of dedup
+		err = simulateDedup(sink, find, paths, keep, *minWasted, out)
+		errHandle(err, "failed to write simulation")
+
+	case len(sources) > 0 || len(targets) > 0:
+		// Content missing from target
+		if len(sources) == 0 || len(targets) == 0 {
+			errHandle(fmt.Errorf("need both -source and -target"), "bad command line")
+		}
+		err = writeMissing(find.MissingFiles(sources, targets), out)
+		errHandle(err, "failed to write output")
+
+	case *matchIdx != "":
+		// Match new files against catalog
+		err = matchIndex(find, paths, *matchIdx, out)
+		errHandle(err, "failed to match index")
+
+	default:
+		// Duplicate groups to apply action to
+		groups := make(map[string][]finder.Dup)
+
+		// Stop gracefully on interrupt
+		ctx, cancel := interruptContext()
+		defer cancel()
+
+		// Find all duplicate files and report to output
+		results := find.AllDuplicateFilesContext(ctx, paths)
+		if *outBuffer > 0 {
+			results = mapreduce.Buffer(results, *outBuffer)
+		}
+		for d := range results {
+			dup, ok := d.(finder.Dup) // Type assert
+			if !ok {
+				continue // Not a duplicate, e.g. processing failure
+			}
+			err := rep.Report(dup)
+			errHandle(err, "failed to write report")
+
+			if act != nil || *consolidate != "" || *previewFS {
+				groups[dup.Group()] = append(groups[dup.Group()], dup)
+			}
+		}
+
+		// Flush report
+		err = rep.Close()
+		errHandle(err, "failed to write report")
+
+		// Don't act on partial results
+		if find.Err() != nil {
+			sink.Warning("interrupted, results are partial")
+			break
+		}
+
+		// Summarize reclaimable space
+		if *previewFS {
+			previewByFilesystem(sink, keep, groups)
+		}
+
+		// Consolidate onto a single device
+		if *consolidate != "" {
+			link := action.WithFallback(action.Reflink, action.Hardlink)
+			consolidateGroups(ctx, sink, link, keep, groups, *consolidate, *minWasted, *dryRun, *verifyAfter)
+		}
+
+		// Apply requested action
+		if act != nil {
+			applyAction(ctx, sink, act, keep, groups, *minWasted, *dryRun, *verifyAfter)
+		}
+	}
+
+	// Keep hashes for the next run
+	if hashCache != nil {
+		errHandle(saveCache(hashCache, *cacheFile), "failed to save -cache")
 	}
 
 	// Update stats
 	find.SetTimeSpent(time.Since(t1))
 
+	// Warn about partial results
+	if find.BudgetExceeded() {
+		sink.Warning(fmt.Sprintf("hashing budget of %d bytes exhausted, results are partial", *maxHashed))
+	}
+
+	// Display content type breakdown if requested
+	if *mimeTypes {
+		reportContentTypes(sink, find.ContentTypes())
+	}
+
 	// Display runtime stats if requested
 	if *stats {
-		log.Printf("INFO stats: %s", find.Stats())
+		find.ReportStats()
 	}
 }
 
+// Create context cancelled on the first interrupt signal. The next one terminates
+// the process as usual.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sig)
+	}()
+	return ctx, cancel
+}
+
+// Flag value collecting all occurrences of the flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// emptyMode is the -empty flag value: "" (disabled), "direct" or "recursive". Given
+// alone, the flag means direct.
+type emptyMode string
+
+func (m *emptyMode) String() string {
+	return string(*m)
+}
+
+func (m *emptyMode) Set(s string) error {
+	switch s {
+	case "true", "direct":
+		*m = "direct"
+	case "false":
+		*m = ""
+	case "recursive":
+		*m = "recursive"
+	default:
+		return fmt.Errorf("unknown mode %q", s)
+	}
+	return nil
+}
+
+// IsBoolFlag allows the flag without a value
+func (m *emptyMode) IsBoolFlag() bool {
+	return true
+}
+
+// Write strings one per line
+func writeLines(lines []string, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(bw, line); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Get paths to scan: args followed by paths listed in the from file, if any. Without
+// either, it's the current directory, while an empty list means nothing to scan.
+func scanPaths(args []string, from string, nul bool) ([]string, error) {
+	paths := args
+	if from != "" {
+		listed, err := readPathList(from, nul)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, listed...)
+	} else if len(paths) == 0 {
+		// Default is current directory
+		paths = []string{"."}
+	}
+	return paths, nil
+}
+
+// Read list of paths separated by newlines or NUL characters. Empty entries are skipped.
+func readPathList(path string, nul bool) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = f.Close() }()
+		r = f
+	}
+
+	sep := byte('\n')
+	if nul {
+		sep = 0
+	}
+
+	var paths []string
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString(sep)
+		line = strings.TrimSuffix(line, string(sep))
+		if !nul {
+			line = strings.TrimSuffix(line, "\r")
+		}
+		if line != "" {
+			paths = append(paths, line)
+		}
+		if err == io.EOF {
+			return paths, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Load hash cache, starting with an empty one if the file doesn't exist yet
+func loadCache(path, algo string) (*cache.Cache, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return cache.New(algo), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return cache.Load(f, algo)
+}
+
+// Save hash cache, replacing the file only once it's completely written
+func saveCache(c *cache.Cache, path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := c.Save(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
 // Get output handle
 func getOutput(path string) (io.WriteCloser, error) {
 	switch path {
@@ -112,6 +656,683 @@ func getOutput(path string) (io.WriteCloser, error) {
 	}
 }
 
+// Write all hashed files as index entries
+func writeIndex(nodes <-chan mapreduce.Value, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for x := range nodes {
+		n := x.Value().(*node.Node) // Type assert
+		if _, err := fmt.Fprintln(bw, index.Entry{Hash: n.Hash, Size: n.Size, Path: n.Path, Digests: n.Digests}); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Report scanned files matching index entries. Only files of sizes present in the index
+// are hashed. Each match is written as hash:size:"file":"catalogued file".
+func matchIndex(find *finder.Finder, paths []string, indexPath string, w io.Writer) error {
+	f, err := os.Open(indexPath)
+	if err != nil {
+		return err
+	}
+	idx, err := index.Load(f)
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	for x := range find.AllFiles(paths, idx.HasSize) {
+		n := x.Value().(*node.Node) // Type assert
+		for _, e := range idx.Lookup(n.Hash) {
+			if _, err := fmt.Fprintf(bw, "%s:%d:%q:%q\n", n.Hash, n.Size, n.Path, e.Path); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// Write paths of files missing from target, one per line
+func writeMissing(nodes <-chan mapreduce.Value, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for x := range nodes {
+		if _, failed := x.(*mapreduce.Failure); failed {
+			continue // Unknown
+		}
+		n := x.Value().(*node.Node) // Type assert
+		if _, err := fmt.Fprintln(bw, n.Path); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Write paths of all unique files and only canonical copies of duplicates, one per line.
+// This is the minimal set of files covering all content.
+func emitCanonical(find *finder.Finder, paths []string, keep finder.KeepPolicy, w io.Writer) error {
+	// Collect duplicate groups first
+	groups := collectGroups(find, paths)
+
+	// All but canonical copies are redundant
+	redundant := make(map[string]bool)
+	for _, dups := range groups {
+		for _, p := range sortedPaths(dups, keep)[1:] {
+			redundant[p] = true
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	for x := range find.ListFiles(paths) {
+		n := x.Value().(*node.Node) // Type assert
+		if redundant[n.Path] {
+			continue
+		}
+		if _, err := fmt.Fprintln(bw, n.Path); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Re-hash files listed in a text report or index and write each entry with its current
+// state as state:hash:"path". State is one of: ok - still a duplicate; missing - file is
+// gone or unreadable; changed - content differs from the report; unique - unchanged, but
+// no other copy is left unchanged.
+func validateReport(sink finder.ProgressSink, find *finder.Finder, reportPath string, w io.Writer) error {
+	f, err := os.Open(reportPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	// Report and index lines have the same leading hash and trailing path
+	var entries []index.Entry
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if d, err := report.ParseText(scanner.Text()); err == nil {
+			entries = append(entries, index.Entry{Hash: d.Hash, Size: d.Size, Path: d.Path})
+			continue
+		}
+		e, err := index.ParseEntry(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// Current hashes
+	paths := make([]string, 0, len(entries))
+	for _, e := range entries {
+		paths = append(paths, e.Path)
+	}
+	current := make(map[string]string)
+	for x := range find.HashFiles(paths) {
+		n := x.Value().(*node.Node) // Type assert
+		current[n.Path] = n.Hash
+	}
+
+	// Unchanged copies by hash
+	intact := make(map[string]int)
+	for _, e := range entries {
+		if current[e.Path] == e.Hash {
+			intact[e.Hash]++
+		}
+	}
+
+	stale := 0
+	bw := bufio.NewWriter(w)
+	for _, e := range entries {
+		hash, ok := current[e.Path]
+		state := "ok"
+		switch {
+		case !ok:
+			state = "missing"
+		case hash != e.Hash:
+			state = "changed"
+		case intact[e.Hash] < 2:
+			state = "unique"
+		}
+		if state != "ok" {
+			stale++
+		}
+		if _, err := fmt.Fprintf(bw, "%s:%s:%q\n", state, e.Hash, e.Path); err != nil {
+			return err
+		}
+	}
+
+	sink.Info(fmt.Sprintf("validated %d entries, %d stale", len(entries), stale))
+	return bw.Flush()
+}
+
+// Merge text reports into one. Members of groups with the same hash are united, so
+// files listed in several reports are only reported once, and counts are recomputed.
+func mergeReports(sink finder.ProgressSink, files []string, rep report.Reporter) error {
+	var (
+		hashes []string                        // Groups in order of appearance
+		groups = make(map[string][]finder.Dup) // Unique members by hash
+		counts = make(map[string]int)          // Largest count seen in a single report
+		seen   = make(map[string]bool)         // Seen hash and path pairs
+	)
+
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		dups, err := report.ReadText(f)
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+
+		for _, d := range dups {
+			if _, ok := groups[d.Hash]; !ok {
+				hashes = append(hashes, d.Hash)
+			}
+			if d.Count > counts[d.Hash] {
+				counts[d.Hash] = d.Count
+			}
+			if key := d.Hash + "\x00" + d.Path; !seen[key] {
+				seen[key] = true
+				groups[d.Hash] = append(groups[d.Hash], d)
+			}
+		}
+	}
+
+	var copies, wasted int64
+	for _, hash := range hashes {
+		dups := groups[hash]
+
+		// Reports might have listed only some members of large groups
+		count := len(dups)
+		if counts[hash] > count {
+			count = counts[hash]
+		}
+		copies += int64(count)
+		wasted += dups[0].Size * int64(count-1)
+
+		for _, d := range dups {
+			d.Count, d.WastedShare = count, 0 // Share is stale after merge
+			if err := rep.Report(d); err != nil {
+				return err
+			}
+		}
+	}
+
+	sink.Info(fmt.Sprintf("merged %d reports: %d groups, %d dups, total wasted space %d bytes", len(files), len(hashes), copies, wasted))
+	return rep.Close()
+}
+
+// Write partial duplicates as prefix-size:"prefix":size:"whole"
+func writePartialDups(dups []finder.PartialDup, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, d := range dups {
+		if _, err := fmt.Fprintln(bw, d); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Write symbolic links pointing to the same targets
+func writeSymlinkDups(dups []finder.SymlinkDup, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, d := range dups {
+		if _, err := fmt.Fprintln(bw, d); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Write projected state of every duplicate after dedup with the copy preferred by keep
+// policy kept. Groups wasting less than minWasted bytes are skipped as a whole.
+func simulateDedup(sink finder.ProgressSink, find *finder.Finder, paths []string, keep finder.KeepPolicy, minWasted int64, w io.Writer) error {
+	// Collect duplicate groups first
+	groups := collectGroups(find, paths)
+
+	// Stable output
+	hashes := make([]string, 0, len(groups))
+	for hash := range groups {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	var kept, linked, skipped, reclaim int64
+
+	bw := bufio.NewWriter(w)
+	for _, hash := range hashes {
+		dups := groups[hash]
+		paths := sortedPaths(dups, keep)
+		plan := action.NewPlan(paths[0], paths[1:], dups[0].Size)
+
+		outcomes := plan.Simulate()
+		if wasted := dups[0].Wasted; wasted < minWasted {
+			// Leave group untouched
+			for i := range outcomes[1:] {
+				o := &outcomes[i+1]
+				o.State, o.Reason = action.Skip, fmt.Sprintf("wasted %d bytes is below threshold", wasted)
+			}
+		} else {
+			reclaim += plan.Reclaim
+		}
+
+		for _, o := range outcomes {
+			switch o.State {
+			case action.Keep:
+				kept++
+			case action.Link:
+				linked++
+			default:
+				skipped++
+			}
+			if _, err := fmt.Fprintf(bw, "%s:%s\n", hash, o); err != nil {
+				return err
+			}
+		}
+	}
+
+	sink.Info(fmt.Sprintf("simulation: %d files kept, %d linked, %d skipped, reclaim %d bytes", kept, linked, skipped, reclaim))
+	return bw.Flush()
+}
+
+// Find all duplicate groups by hash
+func collectGroups(find *finder.Finder, paths []string) map[string][]finder.Dup {
+	groups := make(map[string][]finder.Dup)
+	_ = find.ForEachDuplicate(paths, func(d finder.Dup) error {
+		groups[d.Group()] = append(groups[d.Group()], d)
+		return nil // Never stop
+	})
+	return groups
+}
+
+// Create reporter for the requested output format
+func newReporter(format string, columns []string, separate bool, w io.Writer) (report.Reporter, error) {
+	switch format {
+	case "text":
+		if separate {
+			return report.NewTextSeparated(w), nil
+		}
+		return report.NewText(w), nil
+	case "tsv":
+		return report.NewTSV(w, columns)
+	case "csv":
+		return report.NewCSV(w, columns)
+	case "json":
+		return report.NewJSON(w), nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// Convert -walk-order flag value to the traversal order
+func parseWalkOrder(s string) (fstree.Order, error) {
+	switch s {
+	case "":
+		return fstree.Parallel, nil
+	case "bfs":
+		return fstree.BreadthFirst, nil
+	case "dfs":
+		return fstree.DepthFirst, nil
+	default:
+		return fstree.Parallel, fmt.Errorf("unknown order %q", s)
+	}
+}
+
+// Build progress sink for the -progress-format flag value
+func newSink(format string, w io.Writer) (finder.ProgressSink, error) {
+	switch format {
+	case "", "text":
+		return finder.NewTextSink(w), nil
+	case "json":
+		return finder.NewJSONSink(w), nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// Convert size with optional K, M, G or T suffix (powers of 1024) to bytes
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	if n := len(s); n > 0 {
+		switch strings.ToUpper(s[n-1:]) {
+		case "K":
+			mult = 1 << 10
+		case "M":
+			mult = 1 << 20
+		case "G":
+			mult = 1 << 30
+		case "T":
+			mult = 1 << 40
+		}
+		if mult > 1 {
+			s = s[:n-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("negative size %d", n)
+	}
+	if n > math.MaxInt64/mult {
+		return 0, fmt.Errorf("size %s overflows", s)
+	}
+	return n * mult, nil
+}
+
+// Convert -minsize and -maxsize flag values to bytes. Empty max is no limit, returned as 0.
+func parseSizeRange(min, max string) (int64, int64, error) {
+	minBytes, err := parseSize(min)
+	if err != nil {
+		return 0, 0, fmt.Errorf("-minsize: %v", err)
+	}
+	if max == "" {
+		return minBytes, 0, nil
+	}
+	maxBytes, err := parseSize(max)
+	if err != nil {
+		return 0, 0, fmt.Errorf("-maxsize: %v", err)
+	}
+	if maxBytes < minBytes {
+		return 0, 0, fmt.Errorf("-maxsize %d is less than -minsize %d", maxBytes, minBytes)
+	}
+	return minBytes, maxBytes, nil
+}
+
+// Convert -workers flag value to workers count. For auto, it's picked by storage type
+// of path, falling back to the default when the type can't be detected.
+func parseWorkers(s, path string) (int, error) {
+	if s != "auto" {
+		n, err := strconv.Atoi(s)
+		if err == nil && n < 1 {
+			err = fmt.Errorf("need at least one worker, got %d", n)
+		}
+		return n, err
+	}
+
+	if path == "" {
+		path = "."
+	}
+	if rotational, err := storage.Rotational(path); err == nil && rotational {
+		return rotationalWorkerCount, nil
+	}
+	return defaultWorkerCount, nil
+}
+
+// Convert -follow flag value to the symbolic links policy
+func parseSymlinkPolicy(s string) (fstree.SymlinkPolicy, error) {
+	switch s {
+	case "none":
+		return fstree.NoFollow, nil
+	case "all":
+		return fstree.Follow, nil
+	case "root":
+		return fstree.FollowWithinRoot, nil
+	default:
+		return fstree.NoFollow, fmt.Errorf("unknown policy %q", s)
+	}
+}
+
+// Mode flag and whether it is set
+type mode struct {
+	flag string
+	set  bool
+}
+
+// Make sure at most one of the modes is set
+func checkModes(modes []mode) error {
+	var set []string
+	for _, m := range modes {
+		if m.set {
+			set = append(set, m.flag)
+		}
+	}
+	if len(set) > 1 {
+		return fmt.Errorf("%s are mutually exclusive", strings.Join(set, ", "))
+	}
+	return nil
+}
+
+// Make sure files are never modified based on approximate hashes of -sample-stride
+func checkSampling(stride int64, verify, destructive bool) error {
+	if stride > 0 && destructive && !verify {
+		return fmt.Errorf("modifying files needs -verify with -sample-stride")
+	}
+	return nil
+}
+
+// Convert -action and -action-fallback flag values to the Action
+func parseAction(name, fallback string) (action.Action, error) {
+	var act action.Action
+	switch name {
+	case "":
+		return nil, nil // report only
+	case "reflink":
+		act = action.Reflink
+	default:
+		return nil, fmt.Errorf("unknown action %q", name)
+	}
+
+	switch fallback {
+	case "skip":
+		return act, nil
+	case "hardlink":
+		return action.WithFallback(act, action.Hardlink), nil
+	default:
+		return nil, fmt.Errorf("unknown fallback %q", fallback)
+	}
+}
+
+// Get members of the group it's safe to act on: copies changed since hashed are dropped.
+// It returns nil if the group wastes less than minWasted bytes or less than two copies
+// are left.
+func actionable(sink finder.ProgressSink, hash string, dups []finder.Dup, minWasted int64) []finder.Dup {
+	// Skip small groups
+	if wasted := dups[0].Wasted; wasted < minWasted {
+		sink.Info(fmt.Sprintf("skipping group %s: wasted %d bytes is below threshold", hash, wasted))
+		return nil
+	}
+
+	var kept []finder.Dup
+	for _, d := range dups {
+		changed, err := d.Changed()
+		switch {
+		case err != nil:
+			sink.Warning(fmt.Sprintf("skipping: %v", err))
+		case changed:
+			sink.Warning(fmt.Sprintf("skipping %q: changed since hashed", d.Path))
+		default:
+			kept = append(kept, d)
+		}
+	}
+	if len(kept) < 2 {
+		return nil
+	}
+	return kept
+}
+
+// Apply action to all duplicate groups keeping the copy preferred by keep policy.
+// Groups wasting less than minWasted bytes are left untouched. In dry-run mode only the
+// plan is displayed. If verify is set, canonical files are re-hashed after the action and
+// the run is aborted on mismatch. On interrupt, it stops before the next group.
+func applyAction(ctx context.Context, sink finder.ProgressSink, act action.Action, keep finder.KeepPolicy, groups map[string][]finder.Dup, minWasted int64, dryRun, verify bool) {
+	var projected, reclaimed int64
+
+	for hash, dups := range groups {
+		if ctx.Err() != nil {
+			sink.Warning("interrupted, remaining groups are left untouched")
+			break
+		}
+		if dups = actionable(sink, hash, dups, minWasted); dups == nil {
+			continue
+		}
+
+		paths := sortedPaths(dups, keep)
+		plan := action.NewPlan(paths[0], paths[1:], dups[0].Size)
+		projected += plan.Reclaim
+
+		if dryRun {
+			sink.Info(fmt.Sprintf("dry-run: group %s keep %q, reclaim %d bytes", hash, plan.Canonical, plan.Reclaim))
+			for _, p := range plan.Targets {
+				sink.Info(fmt.Sprintf("dry-run:   %q -> %q", p, plan.Canonical))
+			}
+			continue
+		}
+
+		n, errs := plan.Execute(act)
+		for _, err := range errs {
+			sink.Warning(fmt.Sprintf("skipping: %v", err))
+		}
+		reclaimed += n
+
+		// Make sure we did not damage the only remaining copy
+		if verify {
+			err := plan.Verify(dups[0].Hash)
+			errHandle(err, "ALERT canonical file verification failed")
+		}
+	}
+
+	if dryRun {
+		sink.Info(fmt.Sprintf("dry-run: projected reclaim %d bytes", projected))
+	} else {
+		sink.Info(fmt.Sprintf("reclaimed %d bytes (projected %d)", reclaimed, projected))
+	}
+}
+
+// Display space reclaimable by deduplication broken down by filesystem (device) the kept
+// copies reside on, along with currently free space where it's known.
+func previewByFilesystem(sink finder.ProgressSink, keep finder.KeepPolicy, groups map[string][]finder.Dup) {
+	var (
+		devices []uint64
+		reclaim = make(map[uint64]int64)
+		sample  = make(map[uint64]string) // Some path on the device
+	)
+
+	for hash, dups := range groups {
+		paths := sortedPaths(dups, keep)
+		plan := action.NewPlan(paths[0], paths[1:], dups[0].Size)
+
+		dev, err := action.DeviceOf(plan.Canonical)
+		if err != nil {
+			sink.Warning(fmt.Sprintf("skipping group %s: %v", hash, err))
+			continue
+		}
+		if _, ok := sample[dev]; !ok {
+			devices = append(devices, dev)
+			sample[dev] = plan.Canonical
+		}
+		reclaim[dev] += plan.Reclaim
+	}
+
+	// Biggest first
+	sort.Slice(devices, func(i, j int) bool { return reclaim[devices[i]] > reclaim[devices[j]] })
+
+	for _, dev := range devices {
+		free := "unknown"
+		if n, err := action.FreeSpace(sample[dev]); err == nil {
+			free = fmt.Sprintf("%d bytes", n)
+		}
+		sink.Info(fmt.Sprintf("filesystem %d (%q): reclaimable %d bytes, free %s", dev, filepath.Dir(sample[dev]), reclaim[dev], free))
+	}
+}
+
+// Collapse all duplicate groups onto the device of dir. Groups are chosen, verified and
+// interrupted like in applyAction.
+func consolidateGroups(ctx context.Context, sink finder.ProgressSink, link action.Action, keep finder.KeepPolicy, groups map[string][]finder.Dup, dir string, minWasted int64, dryRun, verify bool) {
+	for hash, dups := range groups {
+		if ctx.Err() != nil {
+			sink.Warning("interrupted, remaining groups are left untouched")
+			break
+		}
+		if dups = actionable(sink, hash, dups, minWasted); dups == nil {
+			continue
+		}
+		paths := sortedPaths(dups, keep)
+
+		c, err := action.NewConsolidation(paths, dir, dups[0].Size)
+		if err != nil {
+			sink.Warning(fmt.Sprintf("skipping group %s: %v", hash, err))
+			continue
+		}
+
+		if dryRun {
+			if c.Source != "" {
+				sink.Info(fmt.Sprintf("dry-run: copy %q -> %q", c.Source, c.Canonical))
+			}
+			for _, p := range c.Link {
+				sink.Info(fmt.Sprintf("dry-run: link %q -> %q", p, c.Canonical))
+			}
+			for _, p := range c.Remove {
+				sink.Info(fmt.Sprintf("dry-run: remove %q", p))
+			}
+			continue
+		}
+
+		for _, err := range c.Execute(link) {
+			sink.Warning(fmt.Sprintf("group %s: %v", hash, err))
+		}
+
+		// Make sure we did not damage the only remaining copy
+		if verify {
+			err := c.Verify(dups[0].Hash)
+			errHandle(err, "ALERT canonical file verification failed")
+		}
+	}
+}
+
+// Get paths of the group ordered by keep policy, canonical first
+func sortedPaths(dups []finder.Dup, keep finder.KeepPolicy) []string {
+	finder.SortByPolicy(dups, keep)
+	paths := make([]string, 0, len(dups))
+	for _, d := range dups {
+		paths = append(paths, d.Path)
+	}
+	return paths
+}
+
+// Convert -keep flag value to the keep policy. Multiple comma-separated policies
+// break ties of each other.
+func parseKeepPolicy(s string) (finder.KeepPolicy, error) {
+	var policies []finder.KeepPolicy
+	for _, name := range strings.Split(s, ",") {
+		switch {
+		case name == "first":
+			policies = append(policies, finder.KeepFirst)
+		case name == "oldest":
+			policies = append(policies, finder.KeepOldest)
+		case name == "newest":
+			policies = append(policies, finder.KeepNewest)
+		case name == "shortest":
+			policies = append(policies, finder.KeepShortestPath)
+		case strings.HasPrefix(name, "dir:"):
+			policies = append(policies, finder.KeepInRoot(strings.TrimPrefix(name, "dir:")))
+		default:
+			return nil, fmt.Errorf("unknown policy %q", name)
+		}
+	}
+	return finder.Chain(policies...), nil
+}
+
+// Log wasted space by content type, biggest first
+func reportContentTypes(sink finder.ProgressSink, wasted map[string]uint64) {
+	types := make([]string, 0, len(wasted))
+	for t := range wasted {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return wasted[types[i]] > wasted[types[j]] })
+
+	for _, t := range types {
+		sink.Info(fmt.Sprintf("content type %s: wasted space %.2fGiB", t, float64(wasted[t])/(1024*1024*1024)))
+	}
+}
+
 // Helper to handle errors
 func errHandle(err error, msg string) {
 	if err != nil {
@@ -0,0 +1,773 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caelifer/dups/action"
+	"github.com/caelifer/dups/finder"
+	"github.com/caelifer/dups/fstree"
+	"github.com/caelifer/dups/index"
+	"github.com/caelifer/dups/node"
+	"github.com/caelifer/dups/report"
+)
+
+// Sink for diagnostics of tested functions
+var sink = finder.NewTextSink(io.Discard)
+
+func TestCheckSampling(t *testing.T) {
+	tests := []struct {
+		stride      int64
+		verify      bool
+		destructive bool
+		ok          bool
+	}{
+		{0, false, false, true},
+		{0, false, true, true},
+		{4096, false, false, true},
+		{4096, false, true, false},
+		{4096, true, true, true},
+	}
+
+	for _, tt := range tests {
+		err := checkSampling(tt.stride, tt.verify, tt.destructive)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkSampling(%d, %v, %v) = %v, want ok %v", tt.stride, tt.verify, tt.destructive, err, tt.ok)
+		}
+	}
+}
+
+func TestActionable(t *testing.T) {
+	dir := t.TempDir()
+	var dups []finder.Dup
+	for _, name := range []string{"a", "b", "c"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dups = append(dups, finder.Dup{Node: node.New(path, fi), Count: 3, Wasted: 8})
+	}
+
+	if got := actionable(sink, "h", dups, 9); got != nil {
+		t.Errorf("group below -action-min-wasted kept: %v", got)
+	}
+	if got := actionable(sink, "h", dups, 8); len(got) != 3 {
+		t.Errorf("kept %d copies, want 3", len(got))
+	}
+
+	// Changed copies are dropped
+	if err := os.WriteFile(dups[2].Path, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := actionable(sink, "h", dups, 0); len(got) != 2 {
+		t.Errorf("kept %d copies, want 2", len(got))
+	}
+	if err := os.WriteFile(dups[1].Path, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := actionable(sink, "h", dups, 0); got != nil {
+		t.Errorf("group with a single unchanged copy kept: %v", got)
+	}
+}
+
+// Create files with the same content in dir, returning them as a duplicate group keyed
+// by the hash
+func writeGroup(t *testing.T, dir, content string, names ...string) map[string][]finder.Dup {
+	t.Helper()
+	groups := make(map[string][]finder.Dup)
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := node.New(path, fi)
+		if err := n.CalculateHash(); err != nil {
+			t.Fatal(err)
+		}
+		size := int64(len(content))
+		groups[n.Hash] = append(groups[n.Hash], finder.Dup{Node: n, Count: len(names), Wasted: size * int64(len(names)-1)})
+	}
+	return groups
+}
+
+// Check whether paths refer to the same file
+func sameFile(a, b string) bool {
+	fa, errA := os.Stat(a)
+	fb, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(fa, fb)
+}
+
+func TestApplyActionInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	groups := writeGroup(t, dir, "data", "a", "b")
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	applyAction(ctx, sink, action.Hardlink, finder.KeepFirst, groups, 0, false, true)
+	if sameFile(a, b) {
+		t.Fatal("files linked after interrupt")
+	}
+
+	applyAction(context.Background(), sink, action.Hardlink, finder.KeepFirst, groups, 0, false, true)
+	if !sameFile(a, b) {
+		t.Fatal("files not linked")
+	}
+}
+
+func TestApplyActionMinWasted(t *testing.T) {
+	dir := t.TempDir()
+	small := writeGroup(t, dir, "small", "s1", "s2")
+	big := writeGroup(t, dir, "big enough", "b1", "b2", "b3")
+	groups := make(map[string][]finder.Dup)
+	for _, g := range []map[string][]finder.Dup{small, big} {
+		for hash, dups := range g {
+			groups[hash] = dups
+		}
+	}
+
+	applyAction(context.Background(), sink, action.Remove, finder.KeepFirst, groups, 10, false, false)
+
+	if _, err := os.Stat(filepath.Join(dir, "s2")); err != nil {
+		t.Errorf("copy of a group below threshold removed: %v", err)
+	}
+	var left int
+	for _, name := range []string{"b1", "b2", "b3"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			left++
+		}
+	}
+	if left != 1 {
+		t.Errorf("%d copies of a group above threshold left, want 1", left)
+	}
+}
+
+func TestApplyActionDryRun(t *testing.T) {
+	dir := t.TempDir()
+	groups := writeGroup(t, dir, "data", "a", "b", "c")
+
+	var out bytes.Buffer
+	applyAction(context.Background(), finder.NewTextSink(&out), action.Remove, finder.KeepFirst, groups, 0, true, false)
+
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("dry-run touched %s: %v", name, err)
+		}
+	}
+	for _, want := range []string{"keep " + strconv.Quote(filepath.Join(dir, "a")), "projected reclaim 8 bytes"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("dry-run output %q lacks %q", out.String(), want)
+		}
+	}
+}
+
+func TestConsolidateDryRun(t *testing.T) {
+	dir, dst := t.TempDir(), t.TempDir()
+	groups := writeGroup(t, dir, "data", "a", "b")
+
+	var out bytes.Buffer
+	consolidateGroups(context.Background(), finder.NewTextSink(&out), action.Hardlink, finder.KeepFirst, groups, dst, 0, true, false)
+
+	if sameFile(filepath.Join(dir, "a"), filepath.Join(dir, "b")) {
+		t.Error("dry-run linked files")
+	}
+	if entries, err := os.ReadDir(dst); err != nil || len(entries) != 0 {
+		t.Errorf("dry-run wrote to destination: %v %v", entries, err)
+	}
+	if !strings.Contains(out.String(), "dry-run:") {
+		t.Errorf("no dry-run plan in %q", out.String())
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"100", 100, true},
+		{"10K", 10 << 10, true},
+		{"10k", 10 << 10, true},
+		{"3M", 3 << 20, true},
+		{"2G", 2 << 30, true},
+		{"5T", 5 << 40, true},
+		{"8388607T", 8388607 << 40, true},
+		{"8388608T", 0, false},
+		{"9223372036854775807", math.MaxInt64, true},
+		{"9223372036854775808", 0, false},
+		{"-1K", 0, false},
+		{"", 0, false},
+		{"K", 0, false},
+		{"10X", 0, false},
+		{"1.5G", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSize(tt.s)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("parseSize(%q) = %d, %v, want %d and ok %v", tt.s, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseSizeRange(t *testing.T) {
+	tests := []struct {
+		min, max string
+		wantMin  int64
+		wantMax  int64
+		ok       bool
+	}{
+		{"0", "", 0, 0, true},
+		{"1K", "1M", 1 << 10, 1 << 20, true},
+		{"1M", "1M", 1 << 20, 1 << 20, true},
+		{"1M", "1K", 0, 0, false},
+		{"2G", "1000", 0, 0, false},
+		{"bad", "1M", 0, 0, false},
+		{"1K", "bad", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		min, max, err := parseSizeRange(tt.min, tt.max)
+		if min != tt.wantMin || max != tt.wantMax || (err == nil) != tt.ok {
+			t.Errorf("parseSizeRange(%q, %q) = %d, %d, %v, want %d, %d and ok %v",
+				tt.min, tt.max, min, max, err, tt.wantMin, tt.wantMax, tt.ok)
+		}
+	}
+}
+
+func TestCheckModes(t *testing.T) {
+	tests := []struct {
+		modes []mode
+		want  string
+	}{
+		{nil, ""},
+		{[]mode{{"-empty", false}, {"-merge-reports", false}}, ""},
+		{[]mode{{"-empty", true}, {"-merge-reports", false}}, ""},
+		{[]mode{{"-emit-canonical-only", true}, {"-empty", false}, {"-merge-reports", true}}, "-emit-canonical-only, -merge-reports are mutually exclusive"},
+		{[]mode{{"-emit-canonical-only", true}, {"-empty", true}, {"-index", true}}, "-emit-canonical-only, -empty, -index are mutually exclusive"},
+	}
+
+	for _, tt := range tests {
+		err := checkModes(tt.modes)
+		if got := fmt.Sprint(err); (err == nil) != (tt.want == "") || err != nil && got != tt.want {
+			t.Errorf("checkModes(%v) = %v, want %q", tt.modes, err, tt.want)
+		}
+	}
+}
+
+func TestParseWalkOrder(t *testing.T) {
+	tests := []struct {
+		s    string
+		want fstree.Order
+		ok   bool
+	}{
+		{"", fstree.Parallel, true},
+		{"bfs", fstree.BreadthFirst, true},
+		{"dfs", fstree.DepthFirst, true},
+		{"random", fstree.Parallel, false},
+	}
+
+	for _, tt := range tests {
+		got, err := parseWalkOrder(tt.s)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("parseWalkOrder(%q) = %v, %v, want %v and ok %v", tt.s, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	tests := []struct {
+		name, fallback string
+		none, ok       bool
+	}{
+		{"", "skip", true, true},
+		{"reflink", "skip", false, true},
+		{"reflink", "hardlink", false, true},
+		{"reflink", "copy", false, false},
+		{"delete", "skip", false, false},
+	}
+
+	for _, tt := range tests {
+		act, err := parseAction(tt.name, tt.fallback)
+		if (err == nil) != tt.ok || (tt.ok && (act == nil) != tt.none) {
+			t.Errorf("parseAction(%q, %q) = %v, %v", tt.name, tt.fallback, act != nil, err)
+		}
+	}
+}
+
+func TestParseSymlinkPolicy(t *testing.T) {
+	tests := []struct {
+		s    string
+		want fstree.SymlinkPolicy
+		ok   bool
+	}{
+		{"none", fstree.NoFollow, true},
+		{"all", fstree.Follow, true},
+		{"root", fstree.FollowWithinRoot, true},
+		{"", fstree.NoFollow, false},
+		{"some", fstree.NoFollow, false},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSymlinkPolicy(tt.s)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("parseSymlinkPolicy(%q) = %v, %v, want %v and ok %v", tt.s, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMatchIndex(t *testing.T) {
+	catalog, incoming := t.TempDir(), t.TempDir()
+	files := map[string]string{
+		filepath.Join(catalog, "a"):       "content a",
+		filepath.Join(catalog, "b"):       "content b",
+		filepath.Join(incoming, "copy"):   "content a",
+		filepath.Join(incoming, "other"):  "content c", // Same size, different content
+		filepath.Join(incoming, "bigger"): "unrelated content",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	find := finder.New(2)
+	find.Sink = sink
+	idx := filepath.Join(t.TempDir(), "index")
+	out, err := os.Create(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeIndex(find.AllFiles([]string{catalog}, nil), out); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	find = finder.New(2)
+	find.Sink = sink
+	var matches bytes.Buffer
+	if err := matchIndex(find, []string{incoming}, idx, &matches); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(matches.String(), "\n"), "\n")
+	want := fmt.Sprintf(":9:%q:%q", filepath.Join(incoming, "copy"), filepath.Join(catalog, "a"))
+	if len(lines) != 1 || !strings.HasSuffix(lines[0], want) {
+		t.Errorf("matched %q, want single match ending with %s", lines, want)
+	}
+	if hashed := find.Summary().BytesHashed; hashed != 2*9 {
+		t.Errorf("hashed %d bytes, want only files of catalogued sizes", hashed)
+	}
+}
+
+func TestParseKeepPolicy(t *testing.T) {
+	old := &node.Node{Path: "/data/long/path", ModTime: time.Unix(1, 0)}
+	short := &node.Node{Path: "/tmp/x", ModTime: time.Unix(2, 0)}
+
+	tests := []struct {
+		s         string
+		preferOld bool
+		ok        bool
+	}{
+		{"oldest", true, true},
+		{"newest", false, true},
+		{"shortest", false, true},
+		{"dir:/data", true, true},
+		{"first,shortest", false, true},
+		{"dir:/elsewhere,oldest", true, true},
+		{"", false, false},
+		{"largest", false, false},
+		{"oldest,bogus", false, false},
+	}
+
+	for _, tt := range tests {
+		keep, err := parseKeepPolicy(tt.s)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseKeepPolicy(%q) error %v, want ok %v", tt.s, err, tt.ok)
+			continue
+		}
+		if got := tt.ok && keep(old, short); got != tt.preferOld {
+			t.Errorf("%q prefers old copy: %v, want %v", tt.s, got, tt.preferOld)
+		}
+	}
+}
+
+func TestEmitCanonical(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "keep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeGroup(t, dir, "content a", "a1", "a2", filepath.Join("keep", "a3"))
+	writeGroup(t, dir, "content c", "c1", "c2")
+	writeGroup(t, dir, "unique", "b")
+
+	tests := []struct {
+		keep finder.KeepPolicy
+		want []string
+	}{
+		{finder.KeepFirst, []string{"a1", "b", "c1"}},
+		{finder.KeepInRoot(filepath.Join(dir, "keep")), []string{"b", "c1", filepath.Join("keep", "a3")}},
+	}
+
+	for _, tt := range tests {
+		find := finder.New(2)
+		find.Sink = sink
+		find.IgnoreHardlinks = true // Listing must not see files of the first scan as links
+		var out bytes.Buffer
+		if err := emitCanonical(find, []string{dir}, tt.keep, &out); err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+			rel, err := filepath.Rel(dir, line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, rel)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("emitted %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestNewSink(t *testing.T) {
+	tests := []struct {
+		format, prefix string
+		ok             bool
+	}{
+		{"", "", true},
+		{"text", "", true},
+		{"json", `{"event":"warning"`, true},
+		{"xml", "", false},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		s, err := newSink(tt.format, &out)
+		if (err == nil) != tt.ok {
+			t.Errorf("newSink(%q) error %v, want ok %v", tt.format, err, tt.ok)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		s.Warning("careful")
+		if !strings.HasPrefix(out.String(), tt.prefix) || !strings.Contains(out.String(), "careful") {
+			t.Errorf("newSink(%q) wrote %q", tt.format, out.String())
+		}
+	}
+}
+
+func TestSimulateDedup(t *testing.T) {
+	dir := t.TempDir()
+	writeGroup(t, dir, "small", "s1", "s2")
+	writeGroup(t, dir, "big enough", "b1", "b2", "b3")
+
+	find := finder.New(2)
+	find.Sink = sink
+	var out, info bytes.Buffer
+	if err := simulateDedup(finder.NewTextSink(&info), find, []string{dir}, finder.KeepFirst, 10, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	states := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		fields := strings.SplitN(line, ":", 4)
+		if len(fields) < 3 {
+			t.Fatalf("malformed line %q", line)
+		}
+		path, err := strconv.Unquote(fields[2])
+		if err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		states[filepath.Base(path)] = fields[1]
+	}
+	want := map[string]string{"b1": "keep", "b2": "link", "b3": "link", "s1": "keep", "s2": "skip"}
+	for name, state := range want {
+		if states[name] != state {
+			t.Errorf("%s: state %q, want %q", name, states[name], state)
+		}
+	}
+	if len(states) != len(want) {
+		t.Errorf("got states %v, want %v", states, want)
+	}
+
+	if !strings.Contains(info.String(), "2 files kept, 2 linked, 1 skipped, reclaim 20 bytes") {
+		t.Errorf("summary %q", info.String())
+	}
+	if sameFile(filepath.Join(dir, "b1"), filepath.Join(dir, "b2")) {
+		t.Error("simulation linked files")
+	}
+}
+
+func TestParseWorkers(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+		ok   bool
+	}{
+		{"1", 1, true},
+		{"16", 16, true},
+		{"0", 0, false},
+		{"-2", -2, false},
+		{"many", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, err := parseWorkers(tt.s, ".")
+		if (err == nil) != tt.ok || (tt.ok && got != tt.want) {
+			t.Errorf("parseWorkers(%q) = %d, %v, want %d and ok %v", tt.s, got, err, tt.want, tt.ok)
+		}
+	}
+
+	// Picked by storage type, which depends on the machine
+	for _, path := range []string{"", t.TempDir(), "missing"} {
+		n, err := parseWorkers("auto", path)
+		if err != nil || (n != rotationalWorkerCount && n != defaultWorkerCount) {
+			t.Errorf("auto workers for %q: %d, %v", path, n, err)
+		}
+	}
+}
+
+func TestMergeReports(t *testing.T) {
+	dir := t.TempDir()
+	reports := map[string]string{
+		"one":    "h1:2:5:\"a\"\nh1:2:5:\"b\"\n",
+		"two":    "h1:4:5:\"b\"\nh1:4:5:\"c\"\nh2:2:7:\"x\"\nh2:2:7:\"y\"\n", // Sampled group
+		"broken": "h1:2:five:\"a\"\n",
+	}
+	for name, content := range reports {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out, info bytes.Buffer
+	files := []string{filepath.Join(dir, "one"), filepath.Join(dir, "two")}
+	if err := mergeReports(finder.NewTextSink(&info), files, report.NewText(&out)); err != nil {
+		t.Fatal(err)
+	}
+	want := "h1:4:5:\"a\"\nh1:4:5:\"b\"\nh1:4:5:\"c\"\nh2:2:7:\"x\"\nh2:2:7:\"y\"\n"
+	if out.String() != want {
+		t.Errorf("merged\n%s\nwant\n%s", out.String(), want)
+	}
+	if !strings.Contains(info.String(), "2 groups, 6 dups, total wasted space 22 bytes") {
+		t.Errorf("summary %q", info.String())
+	}
+
+	for _, name := range []string{"broken", "missing"} {
+		err := mergeReports(sink, append(files, filepath.Join(dir, name)), report.NewText(io.Discard))
+		if err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("%s report: error %v", name, err)
+		}
+	}
+}
+
+func TestValidateReport(t *testing.T) {
+	dir := t.TempDir()
+	var lines []string
+	for _, dups := range writeGroup(t, dir, "content a", "a", "b", "c") {
+		for _, d := range dups {
+			lines = append(lines, d.String())
+		}
+	}
+	for _, dups := range writeGroup(t, dir, "content d", "d", "e") {
+		for _, d := range dups {
+			// Index entries are accepted too
+			e := index.Entry{Hash: d.Hash, Size: d.Size, Path: d.Path, Digests: map[string]string{"md5": "x"}}
+			lines = append(lines, e.String())
+		}
+	}
+
+	rep := filepath.Join(t.TempDir(), "report")
+	if err := os.WriteFile(rep, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "c"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "e")); err != nil {
+		t.Fatal(err)
+	}
+
+	find := finder.New(2)
+	find.Sink = sink
+	var out, info bytes.Buffer
+	if err := validateReport(finder.NewTextSink(&info), find, rep, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	states := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		path, err := strconv.Unquote(fields[2])
+		if err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		states[filepath.Base(path)] = fields[0]
+	}
+	want := map[string]string{"a": "ok", "b": "ok", "c": "changed", "d": "unique", "e": "missing"}
+	for name, state := range want {
+		if states[name] != state {
+			t.Errorf("%s: state %q, want %q", name, states[name], state)
+		}
+	}
+	if !strings.Contains(info.String(), "validated 5 entries, 3 stale") {
+		t.Errorf("summary %q", info.String())
+	}
+
+	// Malformed line
+	if err := os.WriteFile(rep, []byte("not a report\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateReport(sink, find, rep, io.Discard); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("malformed report: error %v", err)
+	}
+}
+
+func TestPreviewByFilesystem(t *testing.T) {
+	dir := t.TempDir()
+	groups := writeGroup(t, dir, "content a", "a1", "a2", "a3")
+	for hash, dups := range writeGroup(t, dir, "content bb", "b1", "b2") {
+		groups[hash] = dups
+	}
+	gone := writeGroup(t, dir, "gone", "g1", "g2")
+	for hash, dups := range gone {
+		groups[hash] = dups
+		for _, d := range dups {
+			if err := os.Remove(d.Path); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := action.DeviceOf(dir); err == action.ErrUnsupported {
+		t.Skip("device IDs not supported")
+	}
+
+	var out bytes.Buffer
+	previewByFilesystem(finder.NewTextSink(&out), finder.KeepFirst, groups)
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %q, want a warning and a single filesystem", lines)
+	}
+	if !strings.Contains(out.String(), "WARN skipping group") {
+		t.Errorf("no warning about missing files in %q", out.String())
+	}
+	if want := fmt.Sprintf("(%q): reclaimable %d bytes", dir, 2*9+10); !strings.Contains(out.String(), want) {
+		t.Errorf("preview %q lacks %q", out.String(), want)
+	}
+}
+
+func TestReadPathList(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		nul   bool
+		want  []string
+	}{
+		{"newlines", "a\nb c\n/d/e\n", false, []string{"a", "b c", "/d/e"}},
+		{"no final newline", "a\nb", false, []string{"a", "b"}},
+		{"crlf", "a\r\nb\r\n", false, []string{"a", "b"}},
+		{"empty entries", "\na\n\n\nb\n\n", false, []string{"a", "b"}},
+		{"empty", "", false, nil},
+		{"nul", "a\x00b\nc\x00d\r\x00", true, []string{"a", "b\nc", "d\r"}},
+		{"nul empty entries", "\x00a\x00\x00b", true, []string{"a", "b"}},
+		{"nul only", "\x00\x00", true, nil},
+	}
+
+	for _, tt := range tests {
+		list := filepath.Join(t.TempDir(), "list")
+		if err := os.WriteFile(list, []byte(tt.input), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readPathList(list, tt.nul)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := readPathList(filepath.Join(t.TempDir(), "missing"), false); err == nil {
+		t.Error("no error for a missing list")
+	}
+
+	// List read from STDIN
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+	go func() {
+		_, _ = io.WriteString(w, "a\x00\x00b\x00")
+		_ = w.Close()
+	}()
+	got, err := readPathList("-", true)
+	if err != nil || fmt.Sprintf("%q", got) != `["a" "b"]` {
+		t.Errorf("got %q, %v from STDIN", got, err)
+	}
+}
+
+func TestScanPaths(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "list")
+	if err := os.WriteFile(list, []byte("/x\n/y\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		from string
+		want []string
+	}{
+		{nil, "", []string{"."}},
+		{[]string{"a", "b"}, "", []string{"a", "b"}},
+		{nil, list, []string{"/x", "/y"}},
+		{[]string{"a"}, list, []string{"a", "/x", "/y"}},
+		{nil, empty, nil},
+	}
+	for _, tt := range tests {
+		got, err := scanPaths(tt.args, tt.from, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+			t.Errorf("scanPaths(%q, %q) = %q, want %q", tt.args, tt.from, got, tt.want)
+		}
+	}
+
+	if _, err := scanPaths(nil, filepath.Join(t.TempDir(), "missing"), false); err == nil {
+		t.Error("no error for a missing list")
+	}
+}
@@ -1,5 +1,11 @@
 package mapreduce
 
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sync"
+)
+
 // Map-Reduce implementation
 
 // MapFn provided by the client code. It is responsible to perform actual work
@@ -23,12 +29,15 @@ func Map(in <-chan Value, mapFn MapFn) <-chan KeyValue {
 type ReduceFn func(out chan<- Value, in <-chan KeyValue)
 
 // Reduce takes input KeyValue channel and reduce function. It returns Value channel
-// used by reducer for output.
+// used by reducer for output. Nil reduceFn sends out only the first value, the rest of
+// the input is drained so that the mapper finishes.
 func Reduce(in <-chan KeyValue, reduceFn ReduceFn) <-chan Value {
 	out := make(chan Value)
 	go func() {
 		if reduceFn == nil {
 			out <- <-in
+			for range in {
+			}
 		} else {
 			reduceFn(out, in)
 		}
@@ -37,6 +46,87 @@ func Reduce(in <-chan KeyValue, reduceFn ReduceFn) <-chan Value {
 	return out
 }
 
+// Sharded builds a ReduceFn which runs n instances of reduceFn concurrently, each with its own
+// state. Input is partitioned by key, so reduceFn must aggregate values of each key
+// independently of other keys, like FilterOutUniques and FilterOutDuplicates do.
+func Sharded(n int, reduceFn ReduceFn) ReduceFn {
+	if n <= 1 {
+		return reduceFn // nothing to shard
+	}
+	return func(out chan<- Value, in <-chan KeyValue) {
+		wg := new(sync.WaitGroup) // Heap
+		shards := make([]chan KeyValue, n)
+
+		// Start sub-reducers
+		for i := range shards {
+			shards[i] = make(chan KeyValue)
+			wg.Add(1)
+			go func(in <-chan KeyValue) {
+				defer wg.Done()
+				reduceFn(out, in)
+			}(shards[i])
+		}
+
+		// Partition input by key
+		for x := range in {
+			shards[shardOf(x.Key(), n)] <- x
+		}
+
+		// Clean-up and wait for all sub-reducers
+		for _, shard := range shards {
+			close(shard)
+		}
+		wg.Wait()
+	}
+}
+
+// Select shard for the key
+func shardOf(key KeyType, n int) int {
+	h := fnv.New32a()
+	if key.isNum {
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], uint64(key.num))
+		_, _ = h.Write(buf[:])
+	} else {
+		_, _ = h.Write([]byte(key.str))
+	}
+	return int(h.Sum32() % uint32(n))
+}
+
+// Buffer decouples consumer of the in channel from its producer by queueing up to size
+// values. Transient consumer slowness doesn't stall the producer until the buffer fills.
+func Buffer(in <-chan Value, size int) <-chan Value {
+	out := make(chan Value, size)
+	go func() {
+		for x := range in {
+			out <- x
+		}
+		close(out) // always clean-up
+	}()
+	return out
+}
+
+// Merge sends values of all in channels to the returned channel, which is closed once
+// all of them are closed.
+func Merge(ins ...<-chan Value) <-chan Value {
+	out := make(chan Value)
+	wg := new(sync.WaitGroup) // Heap
+	for _, in := range ins {
+		wg.Add(1)
+		go func(in <-chan Value) {
+			defer wg.Done()
+			for x := range in {
+				out <- x
+			}
+		}(in)
+	}
+	go func() {
+		wg.Wait()
+		close(out) // always clean-up
+	}()
+	return out
+}
+
 // MapReducePair is a necessary type for pipeline builder
 type MapReducePair struct {
 	Map    MapFn
@@ -53,27 +143,39 @@ func Pipeline(pairs ...MapReducePair) <-chan Value {
 	return out
 }
 
+// PassThrough is a standard reducer that sends out all values as is.
+func PassThrough(out chan<- Value, in <-chan KeyValue) {
+	for x := range in {
+		out <- x
+	}
+}
+
 // FilterOutUniques is a standard reducer that drops values with unique keys sending out
-// the rest of the values.
+// the rest of the values. Only the first value of each key is retained in memory.
 func FilterOutUniques(out chan<- Value, in <-chan KeyValue) {
-	byHash := make(map[KeyType][]Value)
+	// First value for each key; nil once it was sent out
+	byHash := make(map[KeyType]Value)
 
 	for x := range in {
+		// Pass failures through
+		if fl, ok := x.(*Failure); ok {
+			out <- fl
+			continue
+		}
+
 		hash := x.Key()
 
-		if vec, ok := byHash[hash]; ok {
+		if first, ok := byHash[hash]; ok {
 			// Found node with the same hash
-			// Send out aggregated results
-			if len(vec) == 1 {
+			if first != nil {
 				// First time we found duplicate, send first node too
-				out <- vec[0]
+				out <- first
+				byHash[hash] = nil // No need to keep it anymore
 			}
-			// Add new node to a list
-			byHash[hash] = append(vec, x)
 			// Send new node
 			out <- x
 		} else {
-			byHash[hash] = []Value{x}
+			byHash[hash] = x
 		}
 	}
 }
@@ -84,6 +186,12 @@ func FilterOutDuplicates(out chan<- Value, in <-chan KeyValue) {
 	byHash := make(map[KeyType]Value)
 
 	for x := range in {
+		// Pass failures through
+		if fl, ok := x.(*Failure); ok {
+			out <- fl
+			continue
+		}
+
 		key := x.Key()
 		if _, ok := byHash[key]; !ok {
 			// New value, send it record and send it out
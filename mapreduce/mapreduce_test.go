@@ -0,0 +1,175 @@
+package mapreduce
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Simple string value
+type str string
+
+func (s str) Value() interface{} { return string(s) }
+
+// Map sending all words keyed by themselves, and failures for empty ones
+func wordsMap(words ...string) MapFn {
+	return func(out chan<- KeyValue, _ <-chan Value) {
+		for _, w := range words {
+			if w == "" {
+				out <- NewFailure(str(w), errors.New("empty"))
+				continue
+			}
+			out <- NewKVType(KeyTypeFromString(w), str(w))
+		}
+	}
+}
+
+// Collect values and failures sent by the pipeline
+func drain(in <-chan Value) (values []string, failures int) {
+	for x := range in {
+		if fl, ok := x.(*Failure); ok {
+			if fl.Err() != nil {
+				failures++
+			}
+			continue
+		}
+		values = append(values, x.Value().(string))
+	}
+	sort.Strings(values)
+	return values, failures
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFailuresPassThrough(t *testing.T) {
+	words := []string{"a", "", "b", "a", "", "c", "a"}
+
+	tests := []struct {
+		name   string
+		reduce ReduceFn
+		want   []string
+	}{
+		{"uniques", FilterOutUniques, []string{"a", "a", "a"}},
+		{"duplicates", FilterOutDuplicates, []string{"a", "b", "c"}},
+		{"pass", PassThrough, []string{"a", "a", "a", "b", "c"}},
+	}
+
+	for _, tt := range tests {
+		values, failures := drain(Pipeline(MapReducePair{Map: wordsMap(words...), Reduce: tt.reduce}))
+		if !equal(values, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, values, tt.want)
+		}
+		if failures != 2 {
+			t.Errorf("%s: got %d failures, want 2", tt.name, failures)
+		}
+	}
+}
+
+func TestFailureValue(t *testing.T) {
+	err := errors.New("broken")
+	fl := NewFailure(str("x"), err)
+	if fl.Err() != err || fl.Value() != "x" || fl.Key() != (KeyType{}) {
+		t.Errorf("failure holds %v, %v, %v", fl.Err(), fl.Value(), fl.Key())
+	}
+}
+
+func TestSharded(t *testing.T) {
+	words := []string{"a", "", "b", "a", "c", "d", "d", "e", "a", ""}
+
+	for _, reduce := range []ReduceFn{FilterOutUniques, FilterOutDuplicates} {
+		want, _ := drain(Pipeline(MapReducePair{Map: wordsMap(words...), Reduce: reduce}))
+		for _, n := range []int{0, 1, 2, 7} {
+			values, failures := drain(Pipeline(MapReducePair{Map: wordsMap(words...), Reduce: Sharded(n, reduce)}))
+			if !equal(values, want) {
+				t.Errorf("%d shards: got %q, want %q", n, values, want)
+			}
+			if failures != 2 {
+				t.Errorf("%d shards: got %d failures, want 2", n, failures)
+			}
+		}
+	}
+}
+
+func TestShardOf(t *testing.T) {
+	keys := []KeyType{KeyTypeFromString("a"), KeyTypeFromString(""), KeyTypeFromInt64(-1), KeyTypeFromInt64(1 << 40), {}}
+	for _, key := range keys {
+		for _, n := range []int{2, 3, 16} {
+			i := shardOf(key, n)
+			if i < 0 || i >= n {
+				t.Errorf("key %v: shard %d out of %d", key, i, n)
+			}
+			if j := shardOf(key, n); j != i {
+				t.Errorf("key %v: shard %d, then %d", key, i, j)
+			}
+		}
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	in := make(chan Value)
+	out := Buffer(in, 3)
+
+	// Producer is not stalled by the absent consumer until the buffer fills
+	words := []string{"a", "b", "c"}
+	for _, w := range words {
+		select {
+		case in <- str(w):
+		case <-time.After(10 * time.Second):
+			t.Fatalf("stalled sending %q", w)
+		}
+	}
+	close(in)
+
+	var got []string
+	for x := range out {
+		got = append(got, x.Value().(string))
+	}
+	if !equal(got, words) {
+		t.Errorf("got %q, want %q in order", got, words)
+	}
+}
+
+func TestNilReduce(t *testing.T) {
+	values, _ := drain(Pipeline(MapReducePair{Map: wordsMap("a", "b", "c")}))
+	if !equal(values, []string{"a"}) {
+		t.Errorf("got %q, want the first value", values)
+	}
+}
+
+// Map sending n values over keys distinct keys
+func numbersMap(n, keys int) MapFn {
+	return func(out chan<- KeyValue, _ <-chan Value) {
+		for i := 0; i < n; i++ {
+			out <- NewKVType(KeyTypeFromInt(i%keys), str("v"))
+		}
+	}
+}
+
+func BenchmarkSharded(b *testing.B) {
+	const values = 100000
+	for _, reduce := range []struct {
+		name string
+		fn   ReduceFn
+	}{{"uniques", FilterOutUniques}, {"duplicates", FilterOutDuplicates}} {
+		for _, shards := range []int{1, 2, 4, 8} {
+			b.Run(fmt.Sprintf("%s/%d", reduce.name, shards), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					for range Pipeline(MapReducePair{Map: numbersMap(values, values/2), Reduce: Sharded(shards, reduce.fn)}) {
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package mapreduce
+
+import (
+	"sync"
+	"time"
+)
+
+// StageStats is a timing record of a single pipeline stage, see Timed.
+type StageStats struct {
+	Name   string        `json:"name"`
+	Items  uint64        `json:"items"`     // Values produced by the Map function
+	Active time.Duration `json:"active_ns"` // From the first input value until Reduce is done
+}
+
+// Throughput returns the number of items produced per second of active time.
+func (s StageStats) Throughput() float64 {
+	if s.Active <= 0 {
+		return 0
+	}
+	return float64(s.Items) / s.Active.Seconds()
+}
+
+// StageTimer records activity of a pipeline stage. Stages run concurrently in a streaming
+// pipeline, so instead of the whole run each stage is only timed from the moment it
+// receives its first value (or starts, if it has no input) until it's done.
+type StageTimer struct {
+	mu    sync.Mutex
+	name  string
+	items uint64
+	start time.Time
+	end   time.Time
+}
+
+// NewStageTimer creates a timer of the named stage.
+func NewStageTimer(name string) *StageTimer {
+	return &StageTimer{name: name}
+}
+
+// Stats returns stage timing so far.
+func (t *StageTimer) Stats() StageStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := StageStats{Name: t.name, Items: t.items}
+	switch {
+	case t.start.IsZero():
+		// Not started yet
+	case t.end.IsZero():
+		s.Active = time.Since(t.start)
+	default:
+		s.Active = t.end.Sub(t.start)
+	}
+	return s
+}
+
+// Mark the stage started, unless it already is
+func (t *StageTimer) started() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+}
+
+func (t *StageTimer) produced() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.items++
+}
+
+func (t *StageTimer) done() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.end = time.Now()
+}
+
+// Timed wraps pair so that its activity is recorded by t.
+func Timed(pair MapReducePair, t *StageTimer) MapReducePair {
+	mapFn, reduceFn := pair.Map, pair.Reduce
+	return MapReducePair{
+		Map: func(out chan<- KeyValue, in <-chan Value) {
+			// First stage generates values on its own
+			if in == nil {
+				t.started()
+				mapFn(out, in)
+				return
+			}
+
+			// Watch for the first input value
+			relay := make(chan Value)
+			go func() {
+				for x := range in {
+					t.started()
+					relay <- x
+				}
+				close(relay) // always clean-up
+			}()
+			mapFn(out, relay)
+		},
+		Reduce: func(out chan<- Value, in <-chan KeyValue) {
+			defer t.done()
+
+			// Same as Reduce, relay would be left blocked on the values not read
+			if reduceFn == nil {
+				x, ok := <-in
+				if ok {
+					t.produced()
+				}
+				out <- x
+				for range in {
+					t.produced()
+				}
+				return
+			}
+
+			// Count values produced by the Map function
+			relay := make(chan KeyValue)
+			go func() {
+				for x := range in {
+					t.produced()
+					relay <- x
+				}
+				close(relay) // always clean-up
+			}()
+			reduceFn(out, relay)
+		},
+	}
+}
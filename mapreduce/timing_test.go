@@ -0,0 +1,71 @@
+package mapreduce
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestTimed(t *testing.T) {
+	words := []string{"a", "b", "a", "c", "a"}
+	first, second := NewStageTimer("words"), NewStageTimer("dups")
+
+	// Nothing recorded before the pipeline runs
+	if s := first.Stats(); s.Name != "words" || s.Items != 0 || s.Active != 0 || s.Throughput() != 0 {
+		t.Errorf("got %+v before start", s)
+	}
+
+	values, _ := drain(Pipeline(
+		Timed(MapReducePair{Map: wordsMap(words...), Reduce: PassThrough}, first),
+		Timed(MapReducePair{Map: passMap, Reduce: FilterOutUniques}, second),
+	))
+	if !equal(values, []string{"a", "a", "a"}) {
+		t.Errorf("got %q", values)
+	}
+
+	for _, tt := range []struct {
+		timer *StageTimer
+		items uint64
+	}{{first, 5}, {second, 5}} {
+		s := tt.timer.Stats()
+		if s.Items != tt.items || s.Active <= 0 || s.Throughput() <= 0 {
+			t.Errorf("got %+v, want %d items in positive time", s, tt.items)
+		}
+
+		// Done stages don't age
+		time.Sleep(time.Millisecond)
+		if again := tt.timer.Stats(); again.Active != s.Active {
+			t.Errorf("%s: active time grew from %s to %s once done", s.Name, s.Active, again.Active)
+		}
+	}
+}
+
+func TestTimedNilReduce(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	timer := NewStageTimer("first")
+	out := Pipeline(Timed(MapReducePair{Map: wordsMap("a", "b", "c")}, timer))
+	values, _ := drain(out)
+	if len(values) != 1 {
+		t.Errorf("got %q, want the first value", values)
+	}
+	if s := timer.Stats(); s.Items != 3 {
+		t.Errorf("counted %d items, want 3", s.Items)
+	}
+
+	// Mapper and relays are all done
+	deadline := time.Now().Add(10 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left running, had %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Map sending input values keyed by themselves
+func passMap(out chan<- KeyValue, in <-chan Value) {
+	for x := range in {
+		out <- NewKVType(KeyTypeFromString(x.Value().(string)), x)
+	}
+}
@@ -22,28 +22,37 @@ type KeyValue interface {
 
 // Helper concrete types for Key and KeyValue interfaces
 
-type KeyType string
+// KeyType is a comparable key holding either a string or an int64, so numeric keys
+// are used as is, without formatting.
+type KeyType struct {
+	str   string
+	num   int64
+	isNum bool
+}
 
 func (kt KeyType) Key() KeyType {
 	return kt
 }
 
 func (kt KeyType) String() string {
-	return string(kt)
+	if kt.isNum {
+		return strconv.FormatInt(kt.num, 10)
+	}
+	return kt.str
 }
 
 // Helper functions
 
 func KeyTypeFromString(s string) KeyType {
-	return KeyType(s)
+	return KeyType{str: s}
 }
 
 func KeyTypeFromInt64(i int64) KeyType {
-	return KeyType(strconv.FormatInt(i, 10))
+	return KeyType{num: i, isNum: true}
 }
 
 func KeyTypeFromInt(i int) KeyType {
-	return KeyType(strconv.FormatInt(int64(i), 10))
+	return KeyTypeFromInt64(int64(i))
 }
 
 type KVType struct {
@@ -65,3 +74,32 @@ func (kvt KVType) Key() KeyType {
 func (kvt KVType) Value() interface{} {
 	return kvt.val.Value()
 }
+
+// Failure is a KeyValue carrying an error for the value which could not be processed.
+// It lets consumers distinguish failed items from the ones filtered out. Standard
+// reducers pass failures through as is.
+type Failure struct {
+	val Value
+	err error
+}
+
+func NewFailure(v Value, err error) *Failure {
+	return &Failure{
+		val: v,
+		err: err,
+	}
+}
+
+// Key returns an empty key, failures are never aggregated.
+func (f Failure) Key() KeyType {
+	return KeyType{}
+}
+
+func (f Failure) Value() interface{} {
+	return f.val.Value()
+}
+
+// Err returns the processing error.
+func (f Failure) Err() error {
+	return f.err
+}
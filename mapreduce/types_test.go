@@ -0,0 +1,57 @@
+package mapreduce
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestKeyType(t *testing.T) {
+	if KeyTypeFromInt64(42) == KeyTypeFromString("42") {
+		t.Error("numeric key equals string key")
+	}
+	if KeyTypeFromInt(42) != KeyTypeFromInt64(42) {
+		t.Error("int and int64 keys differ")
+	}
+	for _, tt := range []struct {
+		key  KeyType
+		want string
+	}{
+		{KeyTypeFromInt64(-7), "-7"},
+		{KeyTypeFromInt64(1 << 40), "1099511627776"},
+		{KeyTypeFromString("abc"), "abc"},
+		{KeyType{}, ""},
+	} {
+		if got := tt.key.String(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+// Sizes spread over many files, as the size stage sees them
+var benchSizes = func() []int64 {
+	sizes := make([]int64, 10000)
+	for i := range sizes {
+		sizes[i] = int64(i%5000) * 4099
+	}
+	return sizes
+}()
+
+func BenchmarkKeyType(b *testing.B) {
+	for _, bb := range []struct {
+		name string
+		key  func(int64) KeyType
+	}{
+		{"int64", KeyTypeFromInt64},
+		{"formatted", func(i int64) KeyType { return KeyTypeFromString(strconv.FormatInt(i, 10)) }},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				seen := make(map[KeyType]int, len(benchSizes)/2)
+				for _, size := range benchSizes {
+					seen[bb.key(size)]++
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package node
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// Size of buffers used to read file content, a multiple of the memory page size
+var readBufferSize = 16 * os.Getpagesize()
+
+// Reusable read buffers
+var buffers sync.Pool
+
+// SetReadBufferSize sets the size of buffers used to read file content while hashing,
+// rounded up to a multiple of the memory page size. Default is 16 pages. It must be set
+// before any hashing starts.
+func SetReadBufferSize(size int) {
+	page := os.Getpagesize()
+	if size < page {
+		size = page
+	}
+	readBufferSize = (size + page - 1) / page * page
+}
+
+// ReadBufferSize returns the size of buffers used to read file content.
+func ReadBufferSize() int {
+	return readBufferSize
+}
+
+// Like io.CopyN, but reads through a pooled buffer of the configured size
+func copyN(dst io.Writer, src io.Reader, n int64) (int64, error) {
+	buf, ok := buffers.Get().(*[]byte)
+	if !ok || len(*buf) != readBufferSize {
+		b := make([]byte, readBufferSize)
+		buf = &b
+	}
+	defer buffers.Put(buf)
+
+	written, err := io.CopyBuffer(dst, io.LimitReader(src, n), *buf)
+	if written == n {
+		return n, nil
+	}
+	if written < n && err == nil {
+		err = io.EOF // src stopped early
+	}
+	return written, err
+}
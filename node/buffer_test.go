@@ -0,0 +1,90 @@
+package node
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetReadBufferSize(t *testing.T) {
+	defer SetReadBufferSize(16 * os.Getpagesize())
+
+	page := os.Getpagesize()
+	tests := []struct{ size, want int }{
+		{0, page},
+		{1, page},
+		{page, page},
+		{page + 1, 2 * page},
+		{64 * page, 64 * page},
+	}
+
+	content := strings.Repeat("0123456789", 10*page)
+	want := writeNode(t, content)
+	if err := want.CalculateHash(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		SetReadBufferSize(tt.size)
+		if got := ReadBufferSize(); got != tt.want {
+			t.Errorf("size %d: got %d, want %d", tt.size, got, tt.want)
+		}
+
+		// Same hash regardless of buffer size, with pooled buffers of the old size dropped
+		n := writeNode(t, content)
+		if err := n.CalculateHash(); err != nil {
+			t.Fatal(err)
+		}
+		if n.Hash != want.Hash {
+			t.Errorf("size %d: got hash %s, want %s", tt.size, n.Hash, want.Hash)
+		}
+	}
+}
+
+func TestCopyN(t *testing.T) {
+	defer SetReadBufferSize(16 * os.Getpagesize())
+	SetReadBufferSize(1)
+
+	data := strings.Repeat("x", 3*os.Getpagesize()+5)
+	var buf bytes.Buffer
+	if n, err := copyN(&buf, strings.NewReader(data), int64(len(data)-1)); err != nil || n != int64(len(data)-1) {
+		t.Errorf("got %d, %v, want %d bytes", n, err, len(data)-1)
+	}
+	if buf.String() != data[:len(data)-1] {
+		t.Error("copied content differs")
+	}
+
+	// Short source
+	if n, err := copyN(io.Discard, strings.NewReader(data), int64(len(data)+1)); err != io.EOF || n != int64(len(data)) {
+		t.Errorf("got %d, %v, want %d and EOF", n, err, len(data))
+	}
+}
+
+func BenchmarkReadBufferSize(b *testing.B) {
+	defer SetReadBufferSize(16 * os.Getpagesize())
+
+	path := filepath.Join(b.TempDir(), "f")
+	data := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, size := range []int{4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20} {
+		b.Run(fmt.Sprintf("%dK", size>>10), func(b *testing.B) {
+			SetReadBufferSize(size)
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				n := &Node{Path: path, Size: int64(len(data))}
+				if err := n.CalculateHash(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package node
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// Changed reports whether the file size or modification time differs from the ones
+// recorded in the Node.
+func (n *Node) Changed() (bool, error) {
+	info, err := os.Stat(n.Path)
+	if err != nil {
+		return false, err
+	}
+	return info.Size() != n.Size || !info.ModTime().Equal(n.ModTime), nil
+}
+
+// SameContent compares content of two files byte by byte.
+func SameContent(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = fa.Close() }()
+
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = fb.Close() }()
+
+	bufA := make([]byte, readBufferSize)
+	bufB := make([]byte, readBufferSize)
+	for {
+		na, errA := io.ReadFull(fa, bufA)
+		nb, errB := io.ReadFull(fb, bufB)
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+
+		// Both done at the same point
+		doneA := errA == io.EOF || errA == io.ErrUnexpectedEOF
+		doneB := errB == io.EOF || errB == io.ErrUnexpectedEOF
+		switch {
+		case errA != nil && !doneA:
+			return false, errA
+		case errB != nil && !doneB:
+			return false, errB
+		case doneA || doneB:
+			return doneA == doneB, nil
+		}
+	}
+}
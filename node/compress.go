@@ -0,0 +1,48 @@
+package node
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+)
+
+// CompressionRatio estimates how well the Node content compresses by gzipping at most
+// sample bytes from the beginning of the file. It returns the ratio of compressed to
+// original size, so the smaller value means more compressible content.
+func (n *Node) CompressionRatio(sample int64) (float64, error) {
+	// Open file
+	file, err := os.Open(n.Path)
+	if err != nil {
+		return 0, err
+	}
+	// Never forget to close it
+	defer func() { _ = file.Close() }()
+
+	// Compress sample discarding the output, only count bytes
+	cw := new(countingWriter)
+	zw := gzip.NewWriter(cw)
+
+	nbytes, err := io.CopyN(zw, file, sample)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if err = zw.Close(); err != nil {
+		return 0, err
+	}
+
+	// Empty file is not compressible
+	if nbytes == 0 {
+		return 1, nil
+	}
+	return float64(cw.n) / float64(nbytes), nil
+}
+
+// countingWriter discards everything written to it, keeping the count of bytes.
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
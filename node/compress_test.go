@@ -0,0 +1,44 @@
+package node
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Create a file with the content, returning its Node
+func writeNode(t *testing.T, content string) *Node {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &Node{Path: path, Size: int64(len(content))}
+}
+
+func TestCompressionRatio(t *testing.T) {
+	random := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	tests := []struct {
+		name     string
+		content  string
+		min, max float64
+	}{
+		{"repetitive", strings.Repeat("a", 64*1024), 0, 0.01},
+		{"random", string(random), 0.99, 1.1},
+		{"empty", "", 1, 1},
+	}
+
+	for _, tt := range tests {
+		ratio, err := writeNode(t, tt.content).CompressionRatio(32 * 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ratio < tt.min || ratio > tt.max {
+			t.Errorf("%s: ratio %.3f, want within [%.2f, %.2f]", tt.name, ratio, tt.min, tt.max)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package node
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"sort"
+)
+
+// Supported digest algorithms
+var digests = map[string]func() hash.Hash{
+	"fnv64":  func() hash.Hash { return fnv.New64a() },
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// Content hash constructor
+var newHasher = sha1.New
+
+// SetHasher sets the hash used to calculate Node.Hash. Default is SHA1. It must be set
+// before any hashing starts, and hashes calculated with different functions must not be
+// compared, e.g. when matching an index.
+func SetHasher(newHash func() hash.Hash) {
+	newHasher = newHash
+}
+
+// Hasher returns constructor of the named digest algorithm, see DigestNames.
+func Hasher(name string) (func() hash.Hash, error) {
+	if err := CheckDigests([]string{name}); err != nil {
+		return nil, err
+	}
+	return digests[name], nil
+}
+
+// DigestNames returns names of supported digest algorithms.
+func DigestNames() []string {
+	names := make([]string, 0, len(digests))
+	for name := range digests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckDigests makes sure all named digest algorithms are supported.
+func CheckDigests(names []string) error {
+	for _, name := range names {
+		if _, ok := digests[name]; !ok {
+			return fmt.Errorf("unsupported digest %q", name)
+		}
+	}
+	return nil
+}
+
+// Create hashes for named digest algorithms
+func newDigests(names []string) []hash.Hash {
+	hashes := make([]hash.Hash, 0, len(names))
+	for _, name := range names {
+		hashes = append(hashes, digests[name]())
+	}
+	return hashes
+}
@@ -0,0 +1,115 @@
+package node
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"hash/fnv"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCalculateDigests(t *testing.T) {
+	fnvABC := fnv.New64a()
+	fnvABC.Write([]byte("abc"))
+
+	want := map[string]string{
+		"fnv64":  hex.EncodeToString(fnvABC.Sum(nil)),
+		"md5":    "900150983cd24fb0d6963f7d28e17f72",
+		"sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+	}
+	names := []string{"fnv64", "md5", "sha256"}
+
+	n := writeNode(t, "abc")
+	if err := n.CalculateDigests(names); err != nil {
+		t.Fatal(err)
+	}
+	if n.Hash != "a9993e364706816aba3e25717850c26c9cd0d89d" {
+		t.Errorf("content hash %s, want SHA1", n.Hash)
+	}
+	if len(n.Digests) != len(want) {
+		t.Errorf("got digests %v, want %v", n.Digests, want)
+	}
+	for name, d := range want {
+		if n.Digests[name] != d {
+			t.Errorf("%s: got %s, want %s", name, n.Digests[name], d)
+		}
+	}
+
+	if err := writeNode(t, "abc").CalculateDigests([]string{"md5", "crc32"}); err == nil {
+		t.Error("no error for unsupported digest")
+	}
+}
+
+func TestDigestNames(t *testing.T) {
+	names := DigestNames()
+	if got := strings.Join(names, ","); got != "fnv64,md5,sha1,sha256,sha512" {
+		t.Errorf("got %s", got)
+	}
+	if err := CheckDigests(names); err != nil {
+		t.Error(err)
+	}
+	if err := CheckDigests([]string{"sha256", "SHA256"}); err == nil {
+		t.Error("no error for unsupported digest")
+	}
+	if _, err := Hasher("crc32"); err == nil {
+		t.Error("no error for unsupported hasher")
+	}
+}
+
+func TestSetHasher(t *testing.T) {
+	defer SetHasher(sha1.New)
+
+	const content = "abc"
+	for _, name := range DigestNames() {
+		newHash, err := Hasher(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := newHash()
+		h.Write([]byte(content))
+		want := hex.EncodeToString(h.Sum(nil))
+
+		SetHasher(newHash)
+		n := writeNode(t, content)
+		if err := n.CalculateHash(); err != nil {
+			t.Fatal(err)
+		}
+		if n.Hash != want {
+			t.Errorf("%s: got hash %s, want %s", name, n.Hash, want)
+		}
+		if prefix, err := n.PrefixHash(n.Size); err != nil || prefix != want {
+			t.Errorf("%s: got prefix hash %s, %v, want %s", name, prefix, err, want)
+		}
+	}
+}
+
+func BenchmarkHash(b *testing.B) {
+	defer SetHasher(sha1.New)
+
+	path := filepath.Join(b.TempDir(), "f")
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, name := range DigestNames() {
+		newHash, err := Hasher(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(name, func(b *testing.B) {
+			SetHasher(newHash)
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				n := &Node{Path: path, Size: int64(len(data))}
+				if err := n.CalculateHash(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
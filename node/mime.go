@@ -0,0 +1,36 @@
+package node
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Number of bytes considered by http.DetectContentType()
+const sniffLen = 512
+
+// DetectContentType sniffs the beginning of the file and returns its MIME type
+// without parameters, e.g. "image/jpeg".
+func (n *Node) DetectContentType() (string, error) {
+	// Open file
+	file, err := os.Open(n.Path)
+	if err != nil {
+		return "", err
+	}
+	// Never forget to close it
+	defer func() { _ = file.Close() }()
+
+	buf := make([]byte, sniffLen)
+	nbytes, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	// Drop parameters like charset
+	mime := http.DetectContentType(buf[:nbytes])
+	if i := strings.IndexByte(mime, ';'); i >= 0 {
+		mime = mime[:i]
+	}
+	return mime, nil
+}
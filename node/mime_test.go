@@ -0,0 +1,31 @@
+package node
+
+import "testing"
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"plain text\n", "text/plain"}, // Without charset parameter
+		{"<html><body></body></html>", "text/html"},
+		{"\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", "image/png"},
+		{"%PDF-1.4\n", "application/pdf"},
+		{"\x00\x01\x02\x03", "application/octet-stream"},
+		{"", "text/plain"},
+	}
+
+	for _, tt := range tests {
+		got, err := writeNode(t, tt.content).DetectContentType()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.content, got, tt.want)
+		}
+	}
+
+	if _, err := (&Node{Path: "missing"}).DetectContentType(); err == nil {
+		t.Error("no error for missing file")
+	}
+}
@@ -1,19 +1,38 @@
 package node
 
 import (
-	"crypto/sha1"
 	"encoding/hex"
 	"errors"
 	"io"
-	"log"
 	"os"
+	"time"
 )
 
 // Node type
 type Node struct {
-	Path string // File path
-	Size int64  // File size
-	Hash string // String form of SHA1 hash
+	Path     string            // File path
+	Root     string            // Scanned path the file was found under, if known
+	Size     int64             // File size
+	DiskSize int64             // Space allocated on disk
+	ModTime  time.Time         // Modification time
+	Hash     string            // String form of content hash, see SetHasher
+	Digests  map[string]string // Extra digests by algorithm name, see CalculateDigests
+	Dev      uint64            // Device number, zero if unknown
+	Ino      uint64            // Inode number, zero if unknown
+}
+
+// New creates Node from the file information obtained during the walk.
+func New(path string, info os.FileInfo) *Node {
+	n := &Node{Path: path, Size: info.Size(), ModTime: info.ModTime()}
+
+	// Fall back to apparent size if allocation is unknown
+	if size, ok := allocated(info); ok {
+		n.DiskSize = size
+	} else {
+		n.DiskSize = n.Size
+	}
+	n.Dev, n.Ino, _ = identity(info)
+	return n
 }
 
 // Value returns node as a generic value.
@@ -21,35 +40,114 @@ func (n *Node) Value() interface{} {
 	return n
 }
 
-// CalculateHash calculates SHA1 value of the Node.
+// CalculateHash calculates content hash of the Node.
 func (n *Node) CalculateHash() error {
+	return n.CalculateDigests(nil)
+}
+
+// CalculateDigests calculates content hash of the Node along with the named extra digests
+// (see DigestNames) in a single read pass.
+func (n *Node) CalculateDigests(names []string) error {
+	if err := CheckDigests(names); err != nil {
+		return err
+	}
+
 	// Open file
 	file, err := os.Open(n.Path)
 	if err != nil {
-		log.Println("WARN", "CalculateHash", err)
 		return err
 	}
 	// Never forget to close it
 	defer func() { _ = file.Close() }()
 
 	var nbytes int64 // bytes read
-	hash := sha1.New()
+	hash := newHasher()
+
+	// Feed all digests at once
+	var w io.Writer = hash
+	extra := newDigests(names)
+	if len(extra) > 0 {
+		writers := []io.Writer{hash}
+		for _, h := range extra {
+			writers = append(writers, h)
+		}
+		w = io.MultiWriter(writers...)
+	}
 
 	// Always read no more that the file size already determined
-	nbytes, err = io.CopyN(hash, file, n.Size) // Use io.CopyN() for optimal filesystem and memory use
+	nbytes, err = copyN(w, file, n.Size) // Read in page-sized chunks for optimal filesystem and memory use
 	if err != nil && err != io.EOF {
-		log.Println("WARN", "CalculateHash", n.Path, err)
 		return err
 	}
 
 	// Paranoid sanity check
 	if nbytes != n.Size {
 		err = errors.New("Partial read: " + n.Path)
-		log.Println("WARN", err)
 		return err
 	}
 
 	// Add hash value
 	n.Hash = hex.EncodeToString(hash.Sum(nil))
+	if len(names) > 0 {
+		n.Digests = make(map[string]string, len(names))
+		for i, name := range names {
+			n.Digests[name] = hex.EncodeToString(extra[i].Sum(nil))
+		}
+	}
 	return nil
 }
+
+// CalculateHashPrefix calculates content hash of up to the first size bytes of the Node.
+// For files not larger than size it's the same as CalculateHash.
+func (n *Node) CalculateHashPrefix(size int64) error {
+	if size > n.Size {
+		size = n.Size
+	}
+	hash, err := n.PrefixHash(size)
+	if err != nil {
+		return err
+	}
+	n.Hash = hash
+	return nil
+}
+
+// PrefixHash calculates content hash of the first size bytes of the Node. For size equal to
+// the Node size it matches the hash calculated by CalculateHash.
+func (n *Node) PrefixHash(size int64) (string, error) {
+	file, err := os.Open(n.Path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = file.Close() }()
+
+	hash := newHasher()
+	if _, err := copyN(hash, file, size); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// PrefixHashes calculates content hashes of the first bytes of the Node for each of the
+// sizes, given in ascending order, reading the file only once. Each hash matches the one
+// calculated by PrefixHash for the size.
+func (n *Node) PrefixHashes(sizes []int64) ([]string, error) {
+	file, err := os.Open(n.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	hash := newHasher()
+	hashes := make([]string, 0, len(sizes))
+	var read int64
+	for _, size := range sizes {
+		if _, err := copyN(hash, file, size-read); err != nil {
+			return nil, err
+		}
+		read = size
+
+		// Sum leaves the hash state intact, so reading goes on
+		hashes = append(hashes, hex.EncodeToString(hash.Sum(nil)))
+	}
+	return hashes, nil
+}
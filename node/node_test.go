@@ -0,0 +1,22 @@
+package node
+
+import "testing"
+
+func TestPrefixHashes(t *testing.T) {
+	n := writeNode(t, "hello, prefix world")
+
+	sizes := []int64{0, 5, 5, 12, 19}
+	hashes, err := n.PrefixHashes(sizes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, size := range sizes {
+		want, err := n.PrefixHash(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hashes[i] != want {
+			t.Errorf("hash of %d bytes %s, want %s", size, hashes[i], want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package node
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+)
+
+// SampleHash calculates an approximate content fingerprint of the Node from blocks of
+// blockSize bytes read at every stride bytes offset, along with the file size, so files of
+// different sizes never share a fingerprint. Files not larger than stride are hashed in
+// full, so their hash is exact.
+func (n *Node) SampleHash(stride, blockSize int64) error {
+	if n.Size <= stride {
+		return n.CalculateHash()
+	}
+
+	// Open file
+	file, err := os.Open(n.Path)
+	if err != nil {
+		return err
+	}
+	// Never forget to close it
+	defer func() { _ = file.Close() }()
+
+	hash := newHasher()
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(n.Size))
+	_, _ = hash.Write(size[:])
+
+	for off := int64(0); off < n.Size; off += stride {
+		size := blockSize
+		if off+size > n.Size {
+			size = n.Size - off
+		}
+		if _, err := copyN(hash, io.NewSectionReader(file, off, size), size); err != nil {
+			return err
+		}
+	}
+
+	// Add hash value
+	n.Hash = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+// SampledBytes returns the number of bytes SampleHash reads from a file of given size.
+func SampledBytes(size, stride, blockSize int64) int64 {
+	if size <= stride {
+		return size
+	}
+	blocks := (size + stride - 1) / stride
+	last := size - (blocks-1)*stride // Size of the tail after the last stride offset
+	if last > blockSize {
+		last = blockSize
+	}
+	return (blocks-1)*blockSize + last
+}
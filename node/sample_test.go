@@ -0,0 +1,97 @@
+package node
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSampleHashOffsets(t *testing.T) {
+	// Distinct byte at every offset
+	data := make([]byte, 150)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	n := writeNode(t, string(data))
+	if err := n.SampleHash(64, 8); err != nil {
+		t.Fatal(err)
+	}
+
+	// Size, then blocks at offsets 0, 64 and 128
+	h := sha1.New()
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], 150)
+	h.Write(size[:])
+	h.Write(data[0:8])
+	h.Write(data[64:72])
+	h.Write(data[128:136])
+	if want := hex.EncodeToString(h.Sum(nil)); n.Hash != want {
+		t.Errorf("sampled hash %s, want %s", n.Hash, want)
+	}
+
+	// Bytes between samples are not read
+	changed := append([]byte(nil), data...)
+	changed[8], changed[100], changed[149] = 0xff, 0xff, 0xff
+	other := writeNode(t, string(changed))
+	if err := other.SampleHash(64, 8); err != nil {
+		t.Fatal(err)
+	}
+	if other.Hash != n.Hash {
+		t.Error("bytes outside of samples changed the hash")
+	}
+
+	// Bytes in samples are
+	changed[135] = 0xff
+	other = writeNode(t, string(changed))
+	if err := other.SampleHash(64, 8); err != nil {
+		t.Fatal(err)
+	}
+	if other.Hash == n.Hash {
+		t.Error("sampled byte didn't change the hash")
+	}
+}
+
+func TestSampleHashSizes(t *testing.T) {
+	hashOf := func(content string) string {
+		n := writeNode(t, content)
+		if err := n.SampleHash(64, 8); err != nil {
+			t.Fatal(err)
+		}
+		return n.Hash
+	}
+	x := func(n int) string { return string(make([]byte, n)) }
+
+	// Same samples, different sizes
+	if hashOf(x(150)) == hashOf(x(140)) {
+		t.Error("files of different sizes share a fingerprint")
+	}
+
+	// Small files are hashed in full
+	small := writeNode(t, x(64))
+	if err := small.CalculateHash(); err != nil {
+		t.Fatal(err)
+	}
+	if got := hashOf(x(64)); got != small.Hash {
+		t.Errorf("hash of a file within stride %s, want full hash %s", got, small.Hash)
+	}
+}
+
+func TestSampledBytes(t *testing.T) {
+	tests := []struct {
+		size, stride, block, want int64
+	}{
+		{0, 64, 8, 0},
+		{64, 64, 8, 64},  // Hashed in full
+		{65, 64, 8, 9},   // Block and a single byte tail
+		{150, 64, 8, 24}, // Three full blocks
+		{132, 64, 8, 20}, // Short last block
+		{1000, 100, 100, 1000},
+	}
+
+	for _, tt := range tests {
+		if got := SampledBytes(tt.size, tt.stride, tt.block); got != tt.want {
+			t.Errorf("SampledBytes(%d, %d, %d) = %d, want %d", tt.size, tt.stride, tt.block, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package node
+
+import "os"
+
+// Allocation information is not available on this platform
+func allocated(info os.FileInfo) (int64, bool) {
+	return 0, false
+}
+
+// File identity is not available on this platform
+func identity(info os.FileInfo) (dev, ino uint64, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,67 @@
+package report
+
+import (
+	"encoding/csv"
+	"io"
+
+	"github.com/caelifer/dups/finder"
+)
+
+// csvReporter writes selected fields of each duplicate as CSV records after a header
+type csvReporter struct {
+	w       *csv.Writer
+	names   []string
+	columns []column
+	groups  groupNumbers
+	started bool // Header is written
+}
+
+// NewCSV creates Reporter producing CSV output (RFC 4180) with a header row naming the
+// given columns, see NewTSV for supported ones. Fields are quoted as needed, so any path
+// is safe.
+func NewCSV(w io.Writer, names []string) (Reporter, error) {
+	cols, err := selectColumns(names)
+	if err != nil {
+		return nil, err
+	}
+	return &csvReporter{
+		w:       csv.NewWriter(w),
+		names:   names,
+		columns: cols,
+		groups:  make(groupNumbers),
+	}, nil
+}
+
+// Report implements Reporter interface
+func (r *csvReporter) Report(d finder.Dup) error {
+	if err := r.header(); err != nil {
+		return err
+	}
+
+	group := r.groups.of(d.Group())
+
+	record := make([]string, len(r.columns))
+	for i, col := range r.columns {
+		record[i] = col(d, group)
+	}
+	return r.w.Write(record)
+}
+
+// Close implements Reporter interface
+func (r *csvReporter) Close() error {
+	// Header alone for no duplicates
+	if err := r.header(); err != nil {
+		return err
+	}
+	r.w.Flush()
+	return r.w.Error()
+}
+
+// Write header once
+func (r *csvReporter) header() error {
+	if r.started {
+		return nil
+	}
+	r.started = true
+	return r.w.Write(r.names)
+}
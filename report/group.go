@@ -0,0 +1,47 @@
+package report
+
+import (
+	"sort"
+
+	"github.com/caelifer/dups/finder"
+)
+
+// groupReporter is a Reporter decorator that emits members of each duplicate group
+// consecutively, sorted by path.
+type groupReporter struct {
+	Reporter          // Embed wrapped reporter
+	hashes   []string // Groups in order of appearance
+	groups   map[string][]finder.Dup
+}
+
+// Grouped wraps provided Reporter so that all members of each duplicate group are
+// reported together, sorted by path, in order the groups first appeared. It doesn't
+// rely on the order of reported duplicates, so all of them are held in memory until
+// the Reporter is closed.
+func Grouped(r Reporter) Reporter {
+	return &groupReporter{Reporter: r, groups: make(map[string][]finder.Dup)}
+}
+
+// Report implements Reporter interface
+func (g *groupReporter) Report(d finder.Dup) error {
+	if _, ok := g.groups[d.Group()]; !ok {
+		g.hashes = append(g.hashes, d.Group())
+	}
+	g.groups[d.Group()] = append(g.groups[d.Group()], d)
+	return nil
+}
+
+// Close implements Reporter interface
+func (g *groupReporter) Close() error {
+	for _, hash := range g.hashes {
+		dups := g.groups[hash]
+		sort.Slice(dups, func(i, j int) bool { return dups[i].Path < dups[j].Path })
+
+		for _, d := range dups {
+			if err := g.Reporter.Report(d); err != nil {
+				return err
+			}
+		}
+	}
+	return g.Reporter.Close()
+}
@@ -0,0 +1,31 @@
+package report
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	"github.com/caelifer/dups/finder"
+)
+
+// jsonReporter writes each duplicate as a single-line JSON object
+type jsonReporter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// NewJSON creates Reporter producing JSON lines output, one object per duplicate.
+func NewJSON(w io.Writer) Reporter {
+	bw := bufio.NewWriter(w)
+	return &jsonReporter{w: bw, enc: json.NewEncoder(bw)}
+}
+
+// Report implements Reporter interface
+func (r *jsonReporter) Report(d finder.Dup) error {
+	return r.enc.Encode(d)
+}
+
+// Close implements Reporter interface
+func (r *jsonReporter) Close() error {
+	return r.w.Flush()
+}
@@ -0,0 +1,25 @@
+package report
+
+import (
+	"io"
+
+	"github.com/caelifer/dups/finder"
+)
+
+// ParseText parses a single line produced by the text Reporter, see finder.ParseDup.
+func ParseText(line string) (finder.Dup, error) {
+	return finder.ParseDup(line)
+}
+
+// ReadText reads all duplicates from the text Reporter output, see finder.ParseDups.
+func ReadText(r io.Reader) ([]finder.Dup, error) {
+	var dups []finder.Dup
+	in, errc := finder.ParseDups(r)
+	for d := range in {
+		dups = append(dups, d)
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	return dups, nil
+}
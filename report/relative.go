@@ -0,0 +1,50 @@
+package report
+
+import "github.com/caelifer/dups/finder"
+
+// relativeReporter is a Reporter decorator that annotates each duplicate with the share
+// of the total wasted space taken by its group.
+type relativeReporter struct {
+	Reporter // Embed wrapped reporter
+	dups     []finder.Dup
+	total    int64
+}
+
+// RelativeWasted wraps provided Reporter so that each duplicate has WastedShare set to
+// the percentage of total wasted space taken by its group. The total is only known at
+// the end, so all duplicates are held in memory until the Reporter is closed.
+func RelativeWasted(r Reporter) Reporter {
+	return &relativeReporter{Reporter: r}
+}
+
+// Report implements Reporter interface
+func (r *relativeReporter) Report(d finder.Dup) error {
+	// Count each group once, on its first member
+	if len(r.dups) == 0 || r.dups[len(r.dups)-1].Group() != d.Group() {
+		r.total += wastedBy(d)
+	}
+	r.dups = append(r.dups, d)
+	return nil
+}
+
+// Close implements Reporter interface
+func (r *relativeReporter) Close() error {
+	for _, d := range r.dups {
+		if r.total > 0 {
+			d.WastedShare = float64(wastedBy(d)) * 100 / float64(r.total)
+		}
+		if err := r.Reporter.Report(d); err != nil {
+			return err
+		}
+	}
+	return r.Reporter.Close()
+}
+
+// Space wasted by the group of the duplicate, as accounted by Finder. Parsed reports
+// don't carry it, then it's estimated from the apparent size.
+func wastedBy(d finder.Dup) int64 {
+	if d.Wasted > 0 {
+		return d.Wasted
+	}
+	return d.Size * int64(d.Count-1)
+}
@@ -0,0 +1,110 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/caelifer/dups/finder"
+)
+
+// Reporter is an interface for writing found duplicates to the output. Close must be
+// called after the last duplicate is reported to flush any pending output.
+type Reporter interface {
+	Report(d finder.Dup) error
+	Close() error
+}
+
+// textReporter writes each duplicate as a single line using Dup.String() format. Paths
+// are Go-quoted, so non-UTF8 file names are safely rendered with \x escapes.
+type textReporter struct {
+	w        io.Writer
+	separate bool   // Put blank line between groups
+	last     string // Group of the previous duplicate
+}
+
+// NewText creates Reporter producing plain-text output, one duplicate per line.
+func NewText(w io.Writer) Reporter {
+	return &textReporter{w: w}
+}
+
+// NewTextSeparated creates Reporter producing plain-text output, one duplicate per line,
+// with a blank line between consecutive duplicates of different groups.
+func NewTextSeparated(w io.Writer) Reporter {
+	return &textReporter{w: w, separate: true}
+}
+
+// Report implements Reporter interface
+func (r *textReporter) Report(d finder.Dup) error {
+	if r.separate && r.last != "" && r.last != d.Group() {
+		if _, err := fmt.Fprintln(r.w); err != nil {
+			return err
+		}
+	}
+	r.last = d.Group()
+
+	_, err := fmt.Fprintln(r.w, d)
+	return err
+}
+
+// Close implements Reporter interface
+func (r *textReporter) Close() error {
+	return nil
+}
+
+// normalizer is a Reporter decorator that rewrites displayed paths only.
+type normalizer struct {
+	Reporter      // Embed wrapped reporter
+	foldCase bool // Lowercase paths for case-insensitive filesystems
+}
+
+// Normalize wraps provided Reporter so that all reported paths are normalized
+// (see NormalizePath). It never changes which files are considered duplicates.
+func Normalize(r Reporter, foldCase bool) Reporter {
+	return &normalizer{Reporter: r, foldCase: foldCase}
+}
+
+// Report implements Reporter interface
+func (n *normalizer) Report(d finder.Dup) error {
+	// Copy node so we don't change the original
+	nd := *d.Node
+	nd.Path = NormalizePath(nd.Path, n.foldCase)
+	d.Node = &nd
+
+	return n.Reporter.Report(d)
+}
+
+// NormalizePath cleans path, removing redundant and trailing separators, and
+// converts it to use forward slashes. Optionally, path is lowercased, which
+// makes reports comparable between case-sensitive and case-insensitive systems.
+func NormalizePath(path string, foldCase bool) string {
+	p := filepath.ToSlash(filepath.Clean(path))
+	if foldCase {
+		p = toLower(p)
+	}
+	return p
+}
+
+// toLower is like strings.ToLower but keeps bytes of invalid UTF-8 sequences intact
+// instead of replacing them with utf8.RuneError, so non-UTF8 paths are not mangled.
+func toLower(s string) string {
+	if utf8.ValidString(s) {
+		return strings.ToLower(s)
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		if r == utf8.RuneError && size == 1 {
+			b.WriteByte(s[0]) // raw byte
+		} else {
+			b.WriteRune(unicode.ToLower(r))
+		}
+		s = s[size:]
+	}
+	return b.String()
+}
@@ -0,0 +1,230 @@
+package report
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caelifer/dups/finder"
+	"github.com/caelifer/dups/node"
+)
+
+// Reporter collecting reported duplicates
+type sliceReporter struct {
+	dups   []finder.Dup
+	closed bool
+}
+
+func (r *sliceReporter) Report(d finder.Dup) error {
+	r.dups = append(r.dups, d)
+	return nil
+}
+
+func (r *sliceReporter) Close() error {
+	r.closed = true
+	return nil
+}
+
+// Build duplicate of the group with the given hash
+func dup(hash, path string, size int64, count int) finder.Dup {
+	return finder.Dup{Node: &node.Node{Hash: hash, Path: path, Size: size}, Count: count}
+}
+
+// Report all dups to r and close it
+func reportAll(t *testing.T, r Reporter, dups ...finder.Dup) {
+	t.Helper()
+	for _, d := range dups {
+		if err := r.Report(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Get paths of reported duplicates in order
+func pathsOf(dups []finder.Dup) []string {
+	paths := make([]string, 0, len(dups))
+	for _, d := range dups {
+		paths = append(paths, d.Path)
+	}
+	return paths
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTopWastersUsesAccountedWaste(t *testing.T) {
+	// Group b is bigger, but sparse: it wastes less space on disk
+	a1, a2 := dup("a", "a1", 100, 2), dup("a", "a2", 100, 2)
+	b1, b2 := dup("b", "b1", 1000, 2), dup("b", "b2", 1000, 2)
+	a1.Wasted, a2.Wasted = 4096, 4096
+	b1.Wasted, b2.Wasted = 0, 0 // Unknown, estimated from size
+	c1, c2 := dup("c", "c1", 1000, 2), dup("c", "c2", 1000, 2)
+	c1.Wasted, c2.Wasted = 8, 8
+
+	out := new(sliceReporter)
+	reportAll(t, TopWasters(out, 2), a1, a2, b1, b2, c1, c2)
+
+	if want := []string{"a1", "a2", "b1", "b2"}; !equalStrings(pathsOf(out.dups), want) {
+		t.Errorf("reported %q, want %q", pathsOf(out.dups), want)
+	}
+	if !out.closed {
+		t.Error("wrapped reporter not closed")
+	}
+}
+
+func TestTopWasters(t *testing.T) {
+	// Groups of three copies, wasting twice their size
+	var dups []finder.Dup
+	for i, size := range []int64{30, 10, 50, 20, 40} {
+		hash := string(rune('a' + i))
+		for j := 1; j <= 3; j++ {
+			dups = append(dups, dup(hash, hash+strconv.Itoa(j), size, 3))
+		}
+	}
+	// Same hash split by byte comparison is a separate group
+	split := dup("e", "e4", 40, 1)
+	split.Split = 1
+
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{1, "c1 c2 c3"},
+		{3, "c1 c2 c3 e1 e2 e3 a1 a2 a3"},
+		{10, "c1 c2 c3 e1 e2 e3 a1 a2 a3 d1 d2 d3 b1 b2 b3 e4"},
+	}
+
+	for _, tt := range tests {
+		out := new(sliceReporter)
+		reportAll(t, TopWasters(out, tt.n), append(dups, split)...)
+		if got := strings.Join(pathsOf(out.dups), " "); got != tt.want {
+			t.Errorf("top %d: reported %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestRelativeWastedUsesAccountedWaste(t *testing.T) {
+	a1, a2 := dup("a", "a1", 100, 2), dup("a", "a2", 100, 2)
+	b1, b2 := dup("b", "b1", 100, 2), dup("b", "b2", 100, 2)
+	a1.Wasted, a2.Wasted = 3000, 3000
+	b1.Wasted, b2.Wasted = 1000, 1000
+
+	out := new(sliceReporter)
+	reportAll(t, RelativeWasted(out), a1, a2, b1, b2)
+
+	want := []float64{75, 75, 25, 25}
+	for i, d := range out.dups {
+		if d.WastedShare != want[i] {
+			t.Errorf("%s: share %.2f%%, want %.2f%%", d.Path, d.WastedShare, want[i])
+		}
+	}
+}
+
+func TestRelativeWasted(t *testing.T) {
+	// Estimated from sizes: a wastes 2*10, b 1*60; split part of a wastes nothing
+	a1, a2, a3 := dup("a", "a1", 10, 3), dup("a", "a2", 10, 3), dup("a", "a3", 10, 3)
+	a4 := dup("a", "a4", 10, 1)
+	a4.Split = 1
+	b1, b2 := dup("b", "b1", 60, 2), dup("b", "b2", 60, 2)
+
+	out := new(sliceReporter)
+	reportAll(t, RelativeWasted(out), a1, a2, a3, a4, b1, b2)
+
+	want := []float64{25, 25, 25, 0, 75, 75}
+	if len(out.dups) != len(want) {
+		t.Fatalf("reported %d dups, want %d", len(out.dups), len(want))
+	}
+	for i, d := range out.dups {
+		if d.WastedShare != want[i] {
+			t.Errorf("%s: share %.2f%%, want %.2f%%", d.Path, d.WastedShare, want[i])
+		}
+	}
+	if !out.closed {
+		t.Error("wrapped reporter not closed")
+	}
+
+	// Nothing wasted, no division by zero
+	out = new(sliceReporter)
+	reportAll(t, RelativeWasted(out), a4)
+	if len(out.dups) != 1 || out.dups[0].WastedShare != 0 {
+		t.Errorf("got %+v, want zero share", out.dups)
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		path     string
+		foldCase bool
+		want     string
+	}{
+		{"a//b/", false, "a/b"},
+		{"./a/../b", false, "b"},
+		{"A/B", false, "A/B"},
+		{"A/B", true, "a/b"},
+		{"Ä/\xffX", true, "ä/\xffx"}, // Invalid bytes are kept
+	}
+
+	for _, tt := range tests {
+		if got := NormalizePath(tt.path, tt.foldCase); got != tt.want {
+			t.Errorf("NormalizePath(%q, %v) = %q, want %q", tt.path, tt.foldCase, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeKeepsOriginal(t *testing.T) {
+	var r sliceReporter
+	d := dup("h", "A//B", 1, 2)
+	reportAll(t, Normalize(&r, true), d)
+
+	if got := pathsOf(r.dups); !equalStrings(got, []string{"a/b"}) {
+		t.Errorf("reported %q, want a/b", got)
+	}
+	if d.Path != "A//B" {
+		t.Errorf("original path changed to %q", d.Path)
+	}
+	if !r.closed {
+		t.Error("wrapped reporter not closed")
+	}
+}
+
+func TestTSVColumns(t *testing.T) {
+	a1, a2 := dup("a", "a1", 1, 2), dup("a", "a2", 1, 2)
+	a3 := dup("a", "a3", 1, 1)
+	a3.Split = 1 // Same hash, different content
+	b1 := dup("b", "b\t1", 1, 2)
+	b1.ModTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.FixedZone("X", 3600))
+	b1.WastedShare = 12.345
+
+	var out bytes.Buffer
+	r, err := NewTSV(&out, []string{"group-id", "path", "mtime", "wasted-pct"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reportAll(t, r, a1, a2, a3, b1)
+
+	want := "1\ta1\t0001-01-01T00:00:00Z\t0.00\n" +
+		"1\ta2\t0001-01-01T00:00:00Z\t0.00\n" +
+		"2\ta3\t0001-01-01T00:00:00Z\t0.00\n" +
+		"3\t\"b\\t1\"\t2020-01-02T02:04:05Z\t12.35\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+
+	if _, err := NewTSV(&out, []string{"path", "owner"}); err == nil {
+		t.Error("no error for unknown column")
+	}
+}
@@ -0,0 +1,144 @@
+package report
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/caelifer/dups/finder"
+)
+
+// Paths which are hard to keep intact
+var trickyPaths = []string{
+	"plain",
+	"with space",
+	"with:colons:",
+	`with "quotes"`,
+	"with\ttab",
+	"with\nnewline",
+	"with,comma",
+	"ünïcode",
+	"bad\xff\xfeutf8",
+}
+
+// Duplicates with all the tricky paths
+func trickyDups() []finder.Dup {
+	var dups []finder.Dup
+	for i, p := range trickyPaths {
+		dups = append(dups, dup("hash"+strconv.Itoa(i%3), p, int64(i+1), 2))
+	}
+	return dups
+}
+
+// Check that parsed duplicates are the same as the reported ones
+func checkRoundTrip(t *testing.T, want, got []finder.Dup) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("parsed %d dups, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Hash != want[i].Hash || got[i].Count != want[i].Count || got[i].Size != want[i].Size || got[i].Path != want[i].Path {
+			t.Errorf("parsed %q:%d:%d:%q, want %q:%d:%d:%q",
+				got[i].Hash, got[i].Count, got[i].Size, got[i].Path,
+				want[i].Hash, want[i].Count, want[i].Size, want[i].Path)
+		}
+	}
+}
+
+// Parse fields of the default columns
+func parseFields(t *testing.T, fields []string) finder.Dup {
+	t.Helper()
+	if len(fields) != len(DefaultColumns) {
+		t.Fatalf("got fields %q, want %d", fields, len(DefaultColumns))
+	}
+	count, err := strconv.Atoi(fields[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dup(fields[0], fields[3], size, count)
+}
+
+func TestTextRoundTrip(t *testing.T) {
+	want := trickyDups()
+	var out bytes.Buffer
+	reportAll(t, NewTextSeparated(&out), want...)
+
+	got, err := ReadText(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRoundTrip(t, want, got)
+}
+
+func TestTSVRoundTrip(t *testing.T) {
+	want := trickyDups()
+	var out bytes.Buffer
+	r, err := NewTSV(&out, DefaultColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reportAll(t, r, want...)
+
+	var got []finder.Dup
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		for i, f := range fields {
+			if strings.HasPrefix(f, `"`) {
+				if fields[i], err = strconv.Unquote(f); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		got = append(got, parseFields(t, fields))
+	}
+	checkRoundTrip(t, want, got)
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	want := trickyDups()
+	var out bytes.Buffer
+	r, err := NewCSV(&out, DefaultColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reportAll(t, r, want...)
+
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalStrings(records[0], DefaultColumns) {
+		t.Errorf("header %q, want %q", records[0], DefaultColumns)
+	}
+	var got []finder.Dup
+	for _, rec := range records[1:] {
+		got = append(got, parseFields(t, rec))
+	}
+	checkRoundTrip(t, want, got)
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	want := trickyDups()
+	var out bytes.Buffer
+	reportAll(t, NewJSON(&out), want...)
+
+	var got []finder.Dup
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var d finder.Dup
+		if err := dec.Decode(&d); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, d)
+	}
+	checkRoundTrip(t, want, got)
+}
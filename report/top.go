@@ -0,0 +1,90 @@
+package report
+
+import (
+	"container/heap"
+
+	"github.com/caelifer/dups/finder"
+)
+
+// group is a set of reported duplicates with the same hash
+type group struct {
+	dups   []finder.Dup
+	wasted int64
+}
+
+// groupHeap is a min-heap of groups ordered by wasted space
+type groupHeap []*group
+
+func (h groupHeap) Len() int            { return len(h) }
+func (h groupHeap) Less(i, j int) bool  { return h[i].wasted < h[j].wasted }
+func (h groupHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *groupHeap) Push(x interface{}) { *h = append(*h, x.(*group)) }
+func (h *groupHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// topReporter is a Reporter decorator that retains only n groups wasting the most space.
+type topReporter struct {
+	Reporter // Embed wrapped reporter
+	n        int
+	cur      *group // Group being collected
+	top      groupHeap
+}
+
+// TopWasters wraps provided Reporter so that only n duplicate groups wasting the most
+// space are reported, biggest first, when the Reporter is closed. It relies on members
+// of each group being reported consecutively, as Finder does, so only n groups are ever
+// kept in memory.
+func TopWasters(r Reporter, n int) Reporter {
+	return &topReporter{Reporter: r, n: n}
+}
+
+// Report implements Reporter interface
+func (t *topReporter) Report(d finder.Dup) error {
+	// Previous group is complete
+	if t.cur != nil && t.cur.dups[0].Group() != d.Group() {
+		t.push()
+	}
+	if t.cur == nil {
+		t.cur = new(group)
+	}
+	t.cur.dups = append(t.cur.dups, d)
+	return nil
+}
+
+// Close implements Reporter interface
+func (t *topReporter) Close() error {
+	if t.cur != nil {
+		t.push()
+	}
+
+	// Min-heap yields smallest first, so fill from the end
+	groups := make([]*group, t.top.Len())
+	for i := len(groups) - 1; i >= 0; i-- {
+		groups[i] = heap.Pop(&t.top).(*group)
+	}
+
+	for _, g := range groups {
+		for _, d := range g.dups {
+			if err := t.Reporter.Report(d); err != nil {
+				return err
+			}
+		}
+	}
+	return t.Reporter.Close()
+}
+
+// Add current group to the top list, dropping the smallest one if it overflows
+func (t *topReporter) push() {
+	g := t.cur
+	t.cur = nil
+
+	g.wasted = wastedBy(g.dups[0])
+	heap.Push(&t.top, g)
+	if t.top.Len() > t.n {
+		heap.Pop(&t.top)
+	}
+}
@@ -0,0 +1,104 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/caelifer/dups/finder"
+)
+
+// DefaultColumns is the default set of TSV and CSV columns
+var DefaultColumns = []string{"hash", "count", "size", "path"}
+
+// column formats single field of the duplicate; group is the sequential group number
+type column func(d finder.Dup, group int) string
+
+// Supported TSV and CSV columns
+var columns = map[string]column{
+	"hash":       func(d finder.Dup, _ int) string { return d.Hash },
+	"count":      func(d finder.Dup, _ int) string { return strconv.Itoa(d.Count) },
+	"size":       func(d finder.Dup, _ int) string { return strconv.FormatInt(d.Size, 10) },
+	"path":       func(d finder.Dup, _ int) string { return d.Path },
+	"mtime":      func(d finder.Dup, _ int) string { return d.ModTime.UTC().Format(time.RFC3339) },
+	"group-id":   func(_ finder.Dup, group int) string { return strconv.Itoa(group) },
+	"wasted-pct": func(d finder.Dup, _ int) string { return strconv.FormatFloat(d.WastedShare, 'f', 2, 64) },
+}
+
+// tsvReporter writes selected fields of each duplicate separated by tabs
+type tsvReporter struct {
+	w       *bufio.Writer
+	columns []column
+	groups  groupNumbers
+}
+
+// NewTSV creates Reporter producing tab-separated output with the given columns in
+// the given order. Supported columns are hash, count, size, path, mtime, group-id and
+// wasted-pct (requires RelativeWasted).
+func NewTSV(w io.Writer, names []string) (Reporter, error) {
+	r := &tsvReporter{
+		w:      bufio.NewWriter(w),
+		groups: make(groupNumbers),
+	}
+	cols, err := selectColumns(names)
+	if err != nil {
+		return nil, err
+	}
+	r.columns = cols
+	return r, nil
+}
+
+// Look up column formatters by names
+func selectColumns(names []string) ([]column, error) {
+	cols := make([]column, 0, len(names))
+	for _, name := range names {
+		col, ok := columns[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+// groupNumbers assigns sequential numbers to duplicate groups in order of appearance
+type groupNumbers map[string]int
+
+// Get number of the group, see Dup.Group
+func (g groupNumbers) of(group string) int {
+	n, ok := g[group]
+	if !ok {
+		n = len(g) + 1
+		g[group] = n
+	}
+	return n
+}
+
+// Report implements Reporter interface
+func (r *tsvReporter) Report(d finder.Dup) error {
+	group := r.groups.of(d.Group())
+
+	fields := make([]string, len(r.columns))
+	for i, col := range r.columns {
+		fields[i] = tsvEscape(col(d, group))
+	}
+	_, err := fmt.Fprintln(r.w, strings.Join(fields, "\t"))
+	return err
+}
+
+// Close implements Reporter interface
+func (r *tsvReporter) Close() error {
+	return r.w.Flush()
+}
+
+// Fields are written as is unless they would break the format, then they are Go-quoted
+func tsvEscape(field string) string {
+	if strings.ContainsAny(field, "\t\n\r\"") || !utf8.ValidString(field) {
+		return strconv.Quote(field)
+	}
+	return field
+}
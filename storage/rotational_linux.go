@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Root of sysfs block device tree
+var sysBlockDev = "/sys/dev/block"
+
+// Rotational reports whether the file at path resides on rotational storage (HDD) as
+// opposed to solid-state one.
+func Rotational(path string) (bool, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return false, err
+	}
+	return rotational(sysBlockDev, uint64(st.Dev))
+}
+
+// Look up the rotational flag of the device in the sysfs tree. Partitions don't have
+// their own queue, so the parent (whole disk) directory is checked too.
+func rotational(root string, dev uint64) (bool, error) {
+	dir, err := filepath.EvalSymlinks(filepath.Join(root, fmt.Sprintf("%d:%d", major(dev), minor(dev))))
+	if err != nil {
+		return false, ErrUnknown
+	}
+
+	for _, d := range []string{dir, filepath.Dir(dir)} {
+		data, err := ioutil.ReadFile(filepath.Join(d, "queue", "rotational"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		return strings.TrimSpace(string(data)) == "1", nil
+	}
+	return false, ErrUnknown
+}
+
+// Linux device number encoding
+func major(dev uint64) uint64 {
+	return (dev>>8)&0xfff | (dev>>32)&^0xfff
+}
+
+func minor(dev uint64) uint64 {
+	return dev&0xff | (dev>>12)&0xffffff00
+}
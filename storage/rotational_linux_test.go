@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Encode device number the way Linux does
+func mkdev(major, minor uint64) uint64 {
+	return minor&0xff | (major&0xfff)<<8 | (minor&^0xff)<<12 | (major&^0xfff)<<32
+}
+
+func TestRotational(t *testing.T) {
+	// Fake sysfs: HDD with a partition and an SSD
+	root := t.TempDir()
+	devices := filepath.Join(root, "devices")
+	for dir, flag := range map[string]string{"sda": "1", "nvme0n1": "0"} {
+		queue := filepath.Join(devices, dir, "queue")
+		if err := os.MkdirAll(queue, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(queue, "rotational"), []byte(flag+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(devices, "sda", "sda1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	block := filepath.Join(root, "block")
+	if err := os.Mkdir(block, 0755); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"8:0":   filepath.Join(devices, "sda"),
+		"8:1":   filepath.Join(devices, "sda", "sda1"),
+		"259:0": filepath.Join(devices, "nvme0n1"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(block, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		dev  uint64
+		want bool
+		err  error
+	}{
+		{mkdev(8, 0), true, nil},
+		{mkdev(8, 1), true, nil}, // Partition of the disk
+		{mkdev(259, 0), false, nil},
+		{mkdev(8, 16), false, ErrUnknown},
+	}
+
+	for _, tt := range tests {
+		got, err := rotational(block, tt.dev)
+		if got != tt.want || err != tt.err {
+			t.Errorf("device %d:%d: got %v, %v, want %v, %v", major(tt.dev), minor(tt.dev), got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestDeviceNumbers(t *testing.T) {
+	for _, d := range [][2]uint64{{8, 0}, {8, 17}, {259, 3}, {4095, 255}, {4096, 256}, {1 << 20, 1 << 19}} {
+		dev := mkdev(d[0], d[1])
+		if major(dev) != d[0] || minor(dev) != d[1] {
+			t.Errorf("%d:%d decoded as %d:%d", d[0], d[1], major(dev), minor(dev))
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package storage
+
+// Rotational is not supported on this platform.
+func Rotational(path string) (bool, error) {
+	return false, ErrUnknown
+}
@@ -0,0 +1,7 @@
+// Package storage detects properties of the storage devices files reside on.
+package storage
+
+import "errors"
+
+// ErrUnknown is returned when the property can't be detected on this platform or device.
+var ErrUnknown = errors.New("storage: unable to detect")